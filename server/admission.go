@@ -0,0 +1,167 @@
+package server
+
+import (
+	"log"
+	"net"
+	"time"
+)
+
+// AdmissionPolicy is the behavior of stream listeners when MaxConnections are active and a new client connects.
+type AdmissionPolicy int
+
+const (
+	// AdmissionWait does not accept new connections until an active one is closed. Clients wait in the listen queue.
+	AdmissionWait AdmissionPolicy = iota
+	// AdmissionClose accepts the new connection and closes it immediately.
+	AdmissionClose
+	// AdmissionHold accepts the new connection but it is not read until an active one is closed.
+	AdmissionHold
+	// AdmissionReject accepts the new connection, writes RejectBanner and closes it.
+	AdmissionReject
+)
+
+// String returns a text representation of the policy.
+func (ap AdmissionPolicy) String() string {
+	switch ap {
+	case AdmissionWait:
+		return "wait"
+	case AdmissionClose:
+		return "close"
+	case AdmissionHold:
+		return "hold"
+	case AdmissionReject:
+		return "reject"
+	}
+	return "unknown"
+}
+
+// rejectWriteTimeout is the max time to write the banner to rejected connections.
+const rejectWriteTimeout = time.Second
+
+// acceptLoop accepts connections and calls handle for each one admitted, until listener is closed.
+func (scm *ConnectionMgr) acceptLoop(handle func(net.Conn)) {
+	for {
+		if scm.AdmissionPolicy == AdmissionWait && !scm.waitSlot() {
+			return
+		}
+
+		conn, err := scm.listener.Accept()
+		if err != nil {
+			if scm.AdmissionPolicy == AdmissionWait {
+				scm.releaseSlot()
+			}
+			if !scm.isStopping() {
+				log.Printf("Error while accept connection %v\n", err)
+			}
+			return
+		}
+
+		if scm.AdmissionPolicy == AdmissionWait || scm.trySlot() {
+			go scm.handleAdmitted(conn, handle)
+			continue
+		}
+
+		switch scm.AdmissionPolicy {
+		case AdmissionHold:
+			go scm.hold(conn, handle)
+		case AdmissionReject:
+			go scm.reject(conn, scm.RejectBanner)
+		default:
+			go scm.reject(conn, nil)
+		}
+	}
+}
+
+// waitSlot blocks until there is a free slot for a new connection. It returns false if server is stopped before.
+func (scm *ConnectionMgr) waitSlot() bool {
+	if scm.trySlot() {
+		return true
+	}
+
+	select {
+	case scm.slots <- struct{}{}:
+		return true
+	case <-scm.stopped:
+		return false
+	}
+}
+
+// trySlot takes a free slot for a new connection if it is available.
+func (scm *ConnectionMgr) trySlot() bool {
+	select {
+	case scm.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (scm *ConnectionMgr) releaseSlot() {
+	<-scm.slots
+}
+
+func (scm *ConnectionMgr) handleAdmitted(conn net.Conn, handle func(net.Conn)) {
+	defer scm.releaseSlot()
+	handle(conn)
+}
+
+// hold keeps conn open without read it until a slot is free.
+func (scm *ConnectionMgr) hold(conn net.Conn, handle func(net.Conn)) {
+	scm.activeConnsMtx.Lock()
+	scm.heldConns++
+	scm.activeConnsMtx.Unlock()
+
+	admitted := scm.waitSlot()
+
+	scm.activeConnsMtx.Lock()
+	scm.heldConns--
+	scm.activeConnsMtx.Unlock()
+
+	if !admitted {
+		err := conn.Close()
+		if err != nil {
+			log.Println("while close held connection:", err)
+		}
+		return
+	}
+
+	scm.handleAdmitted(conn, handle)
+}
+
+// reject writes banner, if it is not empty, and closes conn.
+func (scm *ConnectionMgr) reject(conn net.Conn, banner []byte) {
+	scm.activeConnsMtx.Lock()
+	scm.rejectedConns++
+	scm.activeConnsMtx.Unlock()
+
+	if len(banner) > 0 {
+		err := conn.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+		if err == nil {
+			_, err = conn.Write(banner)
+		}
+		if err != nil {
+			log.Println("while write banner to rejected connection:", err)
+		}
+	}
+
+	err := conn.Close()
+	if err != nil {
+		log.Println("while close rejected connection:", err)
+	}
+}
+
+// RejectedConnections returns the number of connections closed by the server because MaxConnections was reached.
+// See AdmissionClose and AdmissionReject.
+func (scm *ConnectionMgr) RejectedConnections() int {
+	scm.activeConnsMtx.Lock()
+	defer scm.activeConnsMtx.Unlock()
+	return scm.rejectedConns
+}
+
+// HeldConnections returns the number of connections accepted that are waiting to be read because MaxConnections
+// was reached. See AdmissionHold.
+func (scm *ConnectionMgr) HeldConnections() int {
+	scm.activeConnsMtx.Lock()
+	defer scm.activeConnsMtx.Unlock()
+	return scm.heldConns
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"bufio"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// BeatsEvent is an event received by a Beats listener. It is saved as the Value of the records, and the JSON
+// document of the event is saved as the record Data.
+type BeatsEvent struct {
+	// Seq is the sequence number of the event in the connection.
+	Seq uint32
+	// Fields is the event decoded.
+	Fields map[string]interface{}
+	// Compressed is true if the event was received in a compressed frame.
+	Compressed bool
+}
+
+// BeatsConfig is the configuration of the Lumberjack v2 (Beats protocol) listeners.
+type BeatsConfig struct {
+	// AckDelay is the time to wait before send each ACK.
+	AckDelay time.Duration
+	// SkipAck returns true if the ACK of the window that ends with the event seq, in the connection described by
+	// ci, must not be sent. Events are saved anyway. All ACKs are sent if it is nil.
+	SkipAck func(ci ConnectionInfo, seq uint32) bool
+}
+
+// BeatsListener is a Listener that implements the server side of Lumberjack v2, the protocol used by Beats to send
+// events to Logstash: window size, JSON data, key-value data and compressed frames are decoded, each event is saved
+// as a record and the sequence number of the last event of each window is acknowledged. Framer and Responder are
+// not used.
+type BeatsListener struct {
+	Listener
+	BeatsConfig
+}
+
+// Start starts the server (listener) and enable the input data processing.
+func (bl *BeatsListener) Start() error {
+	bl.protocol = &bl.BeatsConfig
+	return bl.Listener.Start()
+}
+
+// StartContext starts the server like Start, and stops it when ctx is done.
+func (bl *BeatsListener) StartContext(ctx context.Context) error {
+	err := bl.Start()
+	if err != nil {
+		return err
+	}
+
+	stopWhenDone(ctx, bl.stopped, bl.Stop)
+	return nil
+}
+
+// BeatsTLSListener is like BeatsListener but over TLS. TLS settings are the same as TLSListener.
+type BeatsTLSListener struct {
+	TLSListener
+	BeatsConfig
+}
+
+// Start starts the server (listener) and enable the input data processing.
+func (btl *BeatsTLSListener) Start() error {
+	btl.protocol = &btl.BeatsConfig
+	return btl.TLSListener.Start()
+}
+
+// StartContext starts the server like Start, and stops it when ctx is done.
+func (btl *BeatsTLSListener) StartContext(ctx context.Context) error {
+	err := btl.Start()
+	if err != nil {
+		return err
+	}
+
+	stopWhenDone(ctx, btl.stopped, btl.Stop)
+	return nil
+}
+
+const (
+	beatsVersion         = '2'
+	beatsFrameWindowSize = 'W'
+	beatsFrameJSON       = 'J'
+	beatsFrameData       = 'D'
+	beatsFrameCompressed = 'C'
+	beatsFrameAck        = 'A'
+)
+
+// beatsSession is the state of a Beats connection.
+type beatsSession struct {
+	config  *BeatsConfig
+	scm     *ConnectionMgr
+	ps      *PayloadStorage
+	ce      *connEntry
+	maxSize int
+	window  uint32
+	pending uint32
+}
+
+// serveStream implements the streamProtocol interface.
+func (bc *BeatsConfig) serveStream(scm *ConnectionMgr, ps *PayloadStorage, ce *connEntry) error {
+	bs := &beatsSession{
+		config:  bc,
+		scm:     scm,
+		ps:      ps,
+		ce:      ce,
+		maxSize: scm.MaxMessageSize,
+	}
+	if bs.maxSize <= 0 {
+		bs.maxSize = DefaultMaxMessageSize
+	}
+
+	r := bufio.NewReader(&connReader{scm: scm, ce: ce})
+	for {
+		err := bs.readFrame(r, false)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readFrame reads and processes the next frame. It returns io.EOF if r ends before the frame.
+func (bs *beatsSession) readFrame(r io.Reader, compressed bool) error {
+	var header [2]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncatedFrame
+		}
+		return err
+	}
+	if header[0] != beatsVersion {
+		return fmt.Errorf("%w: unsupported lumberjack version %q", ErrInvalidFrame, header[0])
+	}
+
+	switch header[1] {
+	case beatsFrameWindowSize:
+		window, err := readUint32(r)
+		if err != nil {
+			return err
+		}
+		bs.window = window
+		bs.pending = 0
+		return nil
+	case beatsFrameJSON:
+		return bs.readJSON(r, compressed)
+	case beatsFrameData:
+		return bs.readData(r, compressed)
+	case beatsFrameCompressed:
+		return bs.readCompressed(r)
+	}
+	return fmt.Errorf("%w: unknown lumberjack frame type %q", ErrInvalidFrame, header[1])
+}
+
+func (bs *beatsSession) readJSON(r io.Reader, compressed bool) error {
+	seq, err := readUint32(r)
+	if err != nil {
+		return err
+	}
+	payload, err := bs.readBytes(r)
+	if err != nil {
+		return err
+	}
+
+	event := &BeatsEvent{Seq: seq, Compressed: compressed}
+	err = json.Unmarshal(payload, &event.Fields)
+	if err != nil {
+		return fmt.Errorf("%w: invalid JSON in lumberjack event %d: %v", ErrInvalidFrame, seq, err)
+	}
+
+	return bs.saveEvent(payload, event)
+}
+
+// readData reads a D frame, the Lumberjack v2 data frame with the event encoded as key-value pairs.
+func (bs *beatsSession) readData(r io.Reader, compressed bool) error {
+	seq, err := readUint32(r)
+	if err != nil {
+		return err
+	}
+	pairs, err := readUint32(r)
+	if err != nil {
+		return err
+	}
+
+	event := &BeatsEvent{Seq: seq, Compressed: compressed, Fields: map[string]interface{}{}}
+	for i := uint32(0); i < pairs; i++ {
+		key, err := bs.readBytes(r)
+		if err != nil {
+			return err
+		}
+		value, err := bs.readBytes(r)
+		if err != nil {
+			return err
+		}
+		event.Fields[string(key)] = string(value)
+	}
+
+	// Fields are saved as JSON, like in JSON frames
+	payload, err := json.Marshal(event.Fields)
+	if err != nil {
+		return fmt.Errorf("while encode lumberjack event %d: %w", seq, err)
+	}
+	return bs.saveEvent(payload, event)
+}
+
+func (bs *beatsSession) readCompressed(r io.Reader) error {
+	size, err := readUint32(r)
+	if err != nil {
+		return err
+	}
+	if int64(size) > int64(bs.maxSize) {
+		return fmt.Errorf("%w: lumberjack compressed frame of %d bytes", ErrInvalidFrame, size)
+	}
+
+	zr, err := zlib.NewReader(io.LimitReader(r, int64(size)))
+	if err != nil {
+		return fmt.Errorf("%w: invalid lumberjack compressed frame: %v", ErrInvalidFrame, err)
+	}
+	defer zr.Close()
+
+	for {
+		err = bs.readFrame(zr, true)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// saveEvent saves the event and sends the ACK if it is the last one of the window.
+func (bs *beatsSession) saveEvent(payload []byte, event *BeatsEvent) error {
+	bs.scm.saveValue(bs.ps, bs.ce, payload, event)
+
+	bs.pending++
+	if bs.window > 0 && bs.pending < bs.window {
+		return nil
+	}
+	bs.pending = 0
+	return bs.ack(event.Seq)
+}
+
+func (bs *beatsSession) ack(seq uint32) error {
+	if bs.config.SkipAck != nil && bs.config.SkipAck(bs.scm.connectionInfo(bs.ce), seq) {
+		return nil
+	}
+
+	if bs.config.AckDelay > 0 {
+		timer := time.NewTimer(bs.config.AckDelay)
+		select {
+		case <-timer.C:
+		case <-bs.scm.stopped:
+			timer.Stop()
+		}
+	}
+
+	frame := []byte{beatsVersion, beatsFrameAck, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(frame[2:], seq)
+	return bs.scm.writeResponse(bs.ce, frame)
+}
+
+// readBytes reads a field with a 32 bits length.
+func (bs *beatsSession) readBytes(r io.Reader) ([]byte, error) {
+	size, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if int64(size) > int64(bs.maxSize) {
+		return nil, fmt.Errorf("%w: lumberjack field of %d bytes", ErrInvalidFrame, size)
+	}
+
+	b := make([]byte, size)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return nil, truncated(err)
+	}
+	return b, nil
+}
+
+func readUint32(r io.Reader) (uint32, error) {
+	var b [4]byte
+	_, err := io.ReadFull(r, b[:])
+	if err != nil {
+		return 0, truncated(err)
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
+
+// truncated returns ErrTruncatedFrame if err is an EOF in the middle of a frame.
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrTruncatedFrame
+	}
+	return err
+}
+
+// BeatsRecord is a record saved by a Beats listener.
+type BeatsRecord struct {
+	Record
+	// Event is the event received.
+	Event *BeatsEvent
+}
+
+// BeatsRecords returns the events received by Beats listeners in arrival order.
+func (ps *PayloadStorage) BeatsRecords() []BeatsRecord {
+	var r []BeatsRecord
+	for _, rec := range ps.Records() {
+		if event, ok := rec.Value.(*BeatsEvent); ok {
+			r = append(r, BeatsRecord{Record: rec, Event: event})
+		}
+	}
+	return r
+}
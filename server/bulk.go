@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// BulkPath is the path of the Elasticsearch bulk API endpoint. The index can be defined in the path too, like
+// /my-index/_bulk.
+const BulkPath = "/_bulk"
+
+// BulkItem is an operation received by a BulkEndpoint. It is saved as the Value of the records, and the document
+// is saved as the record Data. In delete operations, the action line is saved as Data because there is not any
+// document.
+type BulkItem struct {
+	// Action is the operation: index, create, update or delete.
+	Action string
+	// Index is the target index, from the action metadata or from the request path.
+	Index string
+	// ID is the document identifier. It is generated by the endpoint if it is not defined in index and create
+	// operations.
+	ID string
+	// Document is the source document. It is nil in delete operations.
+	Document json.RawMessage
+}
+
+// BulkEndpoint emulates the Elasticsearch bulk API: the NDJSON body with action and document pairs is decoded,
+// each operation is saved as a record and the result of each one is returned.
+type BulkEndpoint struct {
+	// Fail returns the HTTP status code of the error for the operation number n (starting from 0) in the request.
+	// The operations with status 0 succeed and they are saved, the rest are reported as failed in the response
+	// and they are discarded, so partial failures can be emulated. All operations succeed if it is nil.
+	Fail func(n int, item *BulkItem) int
+
+	mtx    sync.Mutex
+	lastID uint64
+}
+
+// Routes returns the routes to use the endpoint in an HTTPListener.
+func (be *BulkEndpoint) Routes() []HTTPRoute {
+	var r []HTTPRoute
+	for _, method := range []string{http.MethodPost, http.MethodPut} {
+		r = append(r,
+			HTTPRoute{Method: method, Path: BulkPath, Endpoint: be},
+			HTTPRoute{Method: method, Path: "/*" + BulkPath, Endpoint: be},
+		)
+	}
+	return r
+}
+
+type bulkError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+type bulkItemResult struct {
+	Index  string     `json:"_index"`
+	ID     string     `json:"_id"`
+	Status int        `json:"status"`
+	Result string     `json:"result,omitempty"`
+	Error  *bulkError `json:"error,omitempty"`
+}
+
+type bulkResponse struct {
+	Took   int                         `json:"took"`
+	Errors bool                        `json:"errors"`
+	Items  []map[string]bulkItemResult `json:"items"`
+}
+
+// Handle implements the HTTPEndpoint interface.
+func (be *BulkEndpoint) Handle(req *HTTPRequest, save func(data []byte, value interface{})) HTTPResponse {
+	defaultIndex := ""
+	if req.Path != BulkPath {
+		defaultIndex = strings.TrimSuffix(strings.TrimPrefix(req.Path, "/"), BulkPath)
+	}
+
+	type operation struct {
+		item *BulkItem
+		data []byte
+	}
+	var ops []operation
+
+	lines := bytes.Split(req.Body, []byte{'\n'})
+	for i := 0; i < len(lines); i++ {
+		line := bytes.TrimSpace(lines[i])
+		if len(line) == 0 {
+			continue
+		}
+
+		item, err := parseBulkAction(line, defaultIndex)
+		if err != nil {
+			return bulkErrorResponse(fmt.Sprintf("line %d: %v", i+1, err))
+		}
+
+		data := line
+		if item.Action != "delete" {
+			i++
+			if i >= len(lines) || len(bytes.TrimSpace(lines[i])) == 0 {
+				return bulkErrorResponse(fmt.Sprintf("line %d: document expected after action", i+1))
+			}
+			data = bytes.TrimSpace(lines[i])
+			item.Document = data
+		}
+		ops = append(ops, operation{item: item, data: data})
+	}
+	if len(ops) == 0 {
+		return bulkErrorResponse("request body is required")
+	}
+
+	resp := bulkResponse{}
+	for n, op := range ops {
+		if op.item.ID == "" && op.item.Action != "delete" {
+			op.item.ID = be.nextID()
+		}
+
+		result := bulkItemResult{Index: op.item.Index, ID: op.item.ID}
+		status := 0
+		if be.Fail != nil {
+			status = be.Fail(n, op.item)
+		}
+
+		if status != 0 {
+			resp.Errors = true
+			result.Status = status
+			result.Error = &bulkError{Type: bulkErrorType(status), Reason: http.StatusText(status)}
+		} else {
+			result.Status, result.Result = bulkResult(op.item.Action)
+			save(op.data, op.item)
+		}
+		resp.Items = append(resp.Items, map[string]bulkItemResult{op.item.Action: result})
+	}
+
+	// bulkResponse is always encoded without errors
+	data, _ := json.Marshal(resp)
+	return jsonResponse(http.StatusOK, data)
+}
+
+// parseBulkAction parses an action line like {"index":{"_index":"test","_id":"1"}}
+func parseBulkAction(line []byte, defaultIndex string) (*BulkItem, error) {
+	var action map[string]struct {
+		Index string `json:"_index"`
+		ID    string `json:"_id"`
+	}
+	err := json.Unmarshal(line, &action)
+	if err != nil {
+		return nil, fmt.Errorf("while decode action: %w", err)
+	}
+	if len(action) != 1 {
+		return nil, fmt.Errorf("one action is expected, found %d", len(action))
+	}
+
+	for name, meta := range action {
+		switch name {
+		case "index", "create", "update", "delete":
+		default:
+			return nil, fmt.Errorf("unknown action %s", name)
+		}
+
+		item := &BulkItem{Action: name, Index: meta.Index, ID: meta.ID}
+		if item.Index == "" {
+			item.Index = defaultIndex
+		}
+		if item.Index == "" {
+			return nil, fmt.Errorf("index is missing in %s action", name)
+		}
+		if item.ID == "" && (name == "update" || name == "delete") {
+			return nil, fmt.Errorf("id is missing in %s action", name)
+		}
+		return item, nil
+	}
+
+	return nil, fmt.Errorf("action not found")
+}
+
+func (be *BulkEndpoint) nextID() string {
+	be.mtx.Lock()
+	defer be.mtx.Unlock()
+	be.lastID++
+	return strconv.FormatUint(be.lastID, 10)
+}
+
+// bulkResult returns the status and result of a successful operation.
+func bulkResult(action string) (int, string) {
+	switch action {
+	case "update":
+		return http.StatusOK, "updated"
+	case "delete":
+		return http.StatusOK, "deleted"
+	default:
+		return http.StatusCreated, "created"
+	}
+}
+
+// bulkErrorType returns the Elasticsearch error type usually returned with the status.
+func bulkErrorType(status int) string {
+	switch status {
+	case http.StatusTooManyRequests:
+		return "es_rejected_execution_exception"
+	case http.StatusConflict:
+		return "version_conflict_engine_exception"
+	case http.StatusNotFound:
+		return "document_missing_exception"
+	default:
+		return "mapper_parsing_exception"
+	}
+}
+
+func bulkErrorResponse(reason string) HTTPResponse {
+	body := map[string]interface{}{
+		"error":  bulkError{Type: "illegal_argument_exception", Reason: reason},
+		"status": http.StatusBadRequest,
+	}
+	// error body is always encoded without errors
+	data, _ := json.Marshal(body)
+	return jsonResponse(http.StatusBadRequest, data)
+}
+
+// BulkRecord is a record saved by a BulkEndpoint.
+type BulkRecord struct {
+	Record
+	// Item is the operation received.
+	Item *BulkItem
+}
+
+// BulkRecords returns the operations received by bulk endpoints in arrival order.
+func (ps *PayloadStorage) BulkRecords() []BulkRecord {
+	var r []BulkRecord
+	for _, rec := range ps.Records() {
+		if item, ok := rec.Value.(*BulkItem); ok {
+			r = append(r, BulkRecord{Record: rec, Item: item})
+		}
+	}
+	return r
+}
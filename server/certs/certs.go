@@ -0,0 +1,263 @@
+/*
+Package certs builds certificate authorities and certificates to test the TLS servers without fixtures: a root CA,
+intermediate CAs, server certificates and client certificates, all of them PEM encoded and ready to use in
+TLSListener.CertPem, TLSListener.KeyPem and TLSListener.ClientCAs.
+
+Keys are ECDSA P-256 and certificates are valid from one hour ago during DefaultValidity, so they are fast to
+generate and they must not be used out of tests.
+*/
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// DefaultValidity is the validity of the certificates generated.
+const DefaultValidity = time.Hour * 24
+
+// DefaultHosts are the hosts of the server certificates when they are not defined.
+var DefaultHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// Authority is a certificate authority that issues certificates.
+type Authority struct {
+	// Certificate is the CA certificate.
+	Certificate *x509.Certificate
+	// CertPEM is the PEM encoded CA certificate.
+	CertPEM []byte
+	// KeyPEM is the PEM encoded CA private key.
+	KeyPEM []byte
+
+	key    crypto.Signer
+	parent *Authority
+}
+
+// KeyPair is a certificate issued by an Authority and its private key.
+type KeyPair struct {
+	// Certificate is the certificate.
+	Certificate *x509.Certificate
+	// CertPEM is the PEM encoded certificate followed by the certificates of the intermediate CAs that issued it,
+	// so the full chain is sent in the handshakes.
+	CertPEM []byte
+	// KeyPEM is the PEM encoded private key.
+	KeyPEM []byte
+}
+
+// TLSCertificate returns the key pair as a tls.Certificate.
+func (kp *KeyPair) TLSCertificate() (tls.Certificate, error) {
+	return tls.X509KeyPair(kp.CertPEM, kp.KeyPEM)
+}
+
+// NewRootCA returns a new self-signed root CA.
+func NewRootCA(commonName string) (*Authority, error) {
+	key, keyPEM, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := newTemplate(commonName)
+	if err != nil {
+		return nil, err
+	}
+	setCA(template)
+
+	cert, certPEM, err := sign(template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{Certificate: cert, CertPEM: certPEM, KeyPEM: keyPEM, key: key}, nil
+}
+
+// NewIntermediateCA returns a new CA signed by the authority.
+func (a *Authority) NewIntermediateCA(commonName string) (*Authority, error) {
+	key, keyPEM, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := newTemplate(commonName)
+	if err != nil {
+		return nil, err
+	}
+	setCA(template)
+
+	cert, certPEM, err := sign(template, a.Certificate, key.Public(), a.key)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{Certificate: cert, CertPEM: certPEM, KeyPEM: keyPEM, key: key, parent: a}, nil
+}
+
+// NewServerCert returns a new server certificate signed by the authority for the hosts: DNS names or IP
+// addresses. The first host is the common name. DefaultHosts are used if hosts is empty.
+func (a *Authority) NewServerCert(hosts ...string) (*KeyPair, error) {
+	if len(hosts) == 0 {
+		hosts = DefaultHosts
+	}
+
+	template, err := newTemplate(hosts[0])
+	if err != nil {
+		return nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	return a.issue(template)
+}
+
+// NewClientCert returns a new client certificate signed by the authority with the common name.
+func (a *Authority) NewClientCert(commonName string) (*KeyPair, error) {
+	template, err := newTemplate(commonName)
+	if err != nil {
+		return nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	return a.issue(template)
+}
+
+// CertPool returns a pool with the root CA of the authority.
+func (a *Authority) CertPool() *x509.CertPool {
+	root := a
+	for root.parent != nil {
+		root = root.parent
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(root.Certificate)
+	return pool
+}
+
+// issue signs the certificate with a new key.
+func (a *Authority) issue(template *x509.Certificate) (*KeyPair, error) {
+	key, keyPEM, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, certPEM, err := sign(template, a.Certificate, key.Public(), a.key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Chain of intermediate CAs, root is not included
+	for ca := a; ca.parent != nil; ca = ca.parent {
+		certPEM = append(certPEM, ca.CertPEM...)
+	}
+	return &KeyPair{Certificate: cert, CertPEM: certPEM, KeyPEM: keyPEM}, nil
+}
+
+func newKey() (crypto.Signer, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("while generate key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("while encode key: %w", err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func newTemplate(commonName string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("while generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(DefaultValidity),
+	}, nil
+}
+
+func setCA(template *x509.Certificate) {
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+}
+
+func sign(template, parent *x509.Certificate, pub crypto.PublicKey, key crypto.Signer) (*x509.Certificate, []byte, error) {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("while sign certificate %s: %w", template.Subject.CommonName, err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("while parse certificate %s: %w", template.Subject.CommonName, err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// Bundle is a full set of certificates: a root CA, an intermediate CA and the server and client certificates
+// issued by the intermediate one.
+type Bundle struct {
+	Root         *Authority
+	Intermediate *Authority
+	Server       *KeyPair
+	Client       *KeyPair
+}
+
+// NewBundle returns a new bundle. Server certificate is valid for DefaultHosts, and the common name of the client
+// certificate is "client".
+func NewBundle() (*Bundle, error) {
+	root, err := NewRootCA("saverserver Root CA")
+	if err != nil {
+		return nil, err
+	}
+	intermediate, err := root.NewIntermediateCA("saverserver Intermediate CA")
+	if err != nil {
+		return nil, err
+	}
+	server, err := intermediate.NewServerCert()
+	if err != nil {
+		return nil, err
+	}
+	client, err := intermediate.NewClientCert("client")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bundle{Root: root, Intermediate: intermediate, Server: server, Client: client}, nil
+}
+
+// ClientCAs returns the CAs to verify the client certificates, ready for TLSListener.ClientCAs.
+func (b *Bundle) ClientCAs() [][]byte {
+	return [][]byte{b.Root.CertPEM}
+}
+
+// ClientTLSConfig returns the configuration of a client that verifies the server certificate with the root CA
+// and presents the client certificate.
+func (b *Bundle) ClientTLSConfig() *tls.Config {
+	// Certificates generated by the package are always valid
+	cert, _ := b.Client.TLSCertificate()
+	return &tls.Config{
+		RootCAs:      b.Root.CertPool(),
+		Certificates: []tls.Certificate{cert},
+		ServerName:   "localhost",
+		MinVersion:   tls.VersionTLS12,
+	}
+}
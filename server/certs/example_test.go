@@ -0,0 +1,36 @@
+package certs_test
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"github.com/cyberluisda/saverserver-go/server/certs"
+)
+
+func ExampleNewBundle() {
+	bundle, err := certs.NewBundle()
+	if err != nil {
+		panic(err)
+	}
+
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(bundle.Intermediate.Certificate)
+	for _, kp := range []*certs.KeyPair{bundle.Server, bundle.Client} {
+		chains, err := kp.Certificate.Verify(x509.VerifyOptions{
+			Roots:         bundle.Root.CertPool(),
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			panic(err)
+		}
+		for _, c := range chains[0] {
+			fmt.Print(c.Subject.CommonName, "; ")
+		}
+		fmt.Println(kp.Certificate.DNSNames, kp.Certificate.IPAddresses)
+	}
+
+	//Output:
+	// localhost; saverserver Intermediate CA; saverserver Root CA; [localhost] [127.0.0.1 ::1]
+	// client; saverserver Intermediate CA; saverserver Root CA; [] []
+}
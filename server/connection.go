@@ -0,0 +1,368 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// CloseReason is the reason why a connection was closed.
+type CloseReason int
+
+const (
+	// CloseReasonNone is the reason of the connections that are still open.
+	CloseReasonNone CloseReason = iota
+	// CloseReasonEOF is the reason when client closed the connection.
+	CloseReasonEOF
+	// CloseReasonError is the reason when connection was closed due to an error.
+	CloseReasonError
+	// CloseReasonServerStop is the reason when connection was closed because the server was stopped.
+	CloseReasonServerStop
+	// CloseReasonTimeout is the reason when connection was closed because a read or write timeout.
+	CloseReasonTimeout
+	// CloseReasonFault is the reason when connection was closed by a fault injected. See FaultPlan.
+	CloseReasonFault
+)
+
+// String returns a text representation of the reason.
+func (cr CloseReason) String() string {
+	switch cr {
+	case CloseReasonNone:
+		return "none"
+	case CloseReasonEOF:
+		return "EOF"
+	case CloseReasonError:
+		return "error"
+	case CloseReasonServerStop:
+		return "server stop"
+	case CloseReasonTimeout:
+		return "timeout"
+	case CloseReasonFault:
+		return "fault"
+	}
+	return "unknown"
+}
+
+// ConnectionInfo is the record of a connection accepted by a server.
+type ConnectionInfo struct {
+	// ID is the connection identifier. It is unique in the server, and it is assigned in accept order starting
+	// from 1.
+	ID uint64
+	// RemoteAddr is the source address of the client.
+	RemoteAddr string
+	// ClientID is the key used to save the payloads received in this connection. See GetPayload.
+	ClientID string
+	// Opened is the moment when connection was accepted.
+	Opened time.Time
+	// Closed is the moment when connection was closed. Zero value if connection is still open.
+	Closed time.Time
+	// BytesIn is the number of bytes read from the connection.
+	BytesIn int64
+	// BytesOut is the number of bytes written to the connection. See Responder.
+	BytesOut int64
+	// CloseReason is the reason why connection was closed.
+	CloseReason CloseReason
+	// Err is the error that caused the close of the connection if any.
+	Err error
+	// Payload is the data received in this connection and saved in the storage.
+	Payload []byte
+	// Faults is the list of faults injected in this connection. See FaultPlan.
+	Faults []FaultEvent
+	// TLS is the record of the TLS handshake. It is nil if connection is not TLS.
+	TLS *TLSInfo
+	// Identity is the identity of the TLS client. It is nil if connection is not TLS or the handshake failed. See
+	// TLSListener.IdentityFunc.
+	Identity *ClientIdentity
+}
+
+// Active returns true if connection is still open.
+func (ci ConnectionInfo) Active() bool {
+	return ci.Closed.IsZero()
+}
+
+// connEntry is the internal record of a connection.
+type connEntry struct {
+	info ConnectionInfo
+	// conn is the connection used to read and write data
+	conn net.Conn
+	// raw is the connection accepted by the listener, without protocol layers like TLS
+	raw net.Conn
+	// faults is the fault injector of the connection, nil if there are not faults planned
+	faults *faultInjector
+}
+
+// openConn registers a new accepted connection as active.
+func (scm *ConnectionMgr) openConn(conn net.Conn) *connEntry {
+	ce := &connEntry{
+		info: ConnectionInfo{
+			ID:         scm.newConnID(),
+			RemoteAddr: conn.RemoteAddr().String(),
+			Opened:     time.Now(),
+		},
+		conn: conn,
+		raw:  conn,
+	}
+
+	scm.activeConnsMtx.Lock()
+	defer scm.activeConnsMtx.Unlock()
+	scm.activeConns++
+	scm.connLog = append(scm.connLog, ce)
+	scm.connsChanged.notify()
+
+	return ce
+}
+
+// admitConn registers a new accepted connection. It returns false if the connection was refused by the fault plan.
+func (scm *ConnectionMgr) admitConn(conn net.Conn) (*connEntry, bool) {
+	ce := scm.openConn(conn)
+	if scm.Faults == nil {
+		return ce, true
+	}
+
+	if scm.Faults.refuses(ce.info.ID) {
+		scm.recordFault(ce, FaultRefuse)
+		resetConn(conn)
+		scm.closeConn(ce, CloseReasonFault, fmt.Errorf("%w: connection refused", ErrFaultInjected))
+		return nil, false
+	}
+
+	ce.faults = scm.Faults.injector(scm, ce)
+	return ce, true
+}
+
+// wrapConn replaces the connection used to read and write data, for example to add the TLS layer.
+func (scm *ConnectionMgr) wrapConn(ce *connEntry, conn net.Conn) {
+	scm.activeConnsMtx.Lock()
+	defer scm.activeConnsMtx.Unlock()
+	ce.conn = conn
+}
+
+// closeConn closes the connection and marks it as closed with the reason.
+func (scm *ConnectionMgr) closeConn(ce *connEntry, reason CloseReason, err error) {
+	errClose := ce.conn.Close()
+	if errClose != nil && !errors.Is(errClose, net.ErrClosed) {
+		log.Println("while close connection:", errClose)
+	}
+
+	scm.activeConnsMtx.Lock()
+	defer scm.activeConnsMtx.Unlock()
+	scm.activeConns--
+	ce.info.Closed = time.Now()
+	ce.info.CloseReason = reason
+	ce.info.Err = err
+	scm.connsChanged.notify()
+}
+
+// closeReasonOf returns the reason to close a connection after a read error.
+func (scm *ConnectionMgr) closeReasonOf(err error) CloseReason {
+	if err == io.EOF {
+		return CloseReasonEOF
+	}
+	if errors.Is(err, ErrFaultInjected) {
+		return CloseReasonFault
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return CloseReasonTimeout
+	}
+
+	scm.activeConnsMtx.Lock()
+	defer scm.activeConnsMtx.Unlock()
+	if scm.stopping && errors.Is(err, net.ErrClosed) {
+		return CloseReasonServerStop
+	}
+
+	return CloseReasonError
+}
+
+// serveConn reads data from the connection until it is closed, saving it in ps with clientID as key.
+func (scm *ConnectionMgr) serveConn(ps *PayloadStorage, ce *connEntry, clientID string) {
+	scm.activeConnsMtx.Lock()
+	ce.info.ClientID = clientID
+	scm.activeConnsMtx.Unlock()
+
+	var err error
+	if scm.protocol != nil {
+		err = scm.protocol.serveStream(scm, ps, ce)
+	} else {
+		err = scm.greet(ce)
+		if err == nil && scm.Framer == nil {
+			err = scm.readRaw(ps, ce)
+		} else if err == nil {
+			err = scm.readFrames(ps, ce)
+		}
+	}
+
+	reason := scm.closeReasonOf(err)
+	switch reason {
+	case CloseReasonEOF:
+		err = nil
+	case CloseReasonServerStop, CloseReasonFault:
+	default:
+		log.Println("while read from connection:", err)
+	}
+	scm.closeConn(ce, reason, err)
+}
+
+// streamProtocol is implemented by the listeners of protocols that need to reply to the clients, like RELP. When it
+// is defined, it replaces the Framer and Responder handling of the connections.
+type streamProtocol interface {
+	// serveStream reads and replies the messages from the connection until it is closed. It returns io.EOF when
+	// the session is closed normally.
+	serveStream(scm *ConnectionMgr, ps *PayloadStorage, ce *connEntry) error
+}
+
+// readRaw saves each chunk of data read from the connection as a message. It returns io.EOF when connection is
+// closed by the client.
+func (scm *ConnectionMgr) readRaw(ps *PayloadStorage, ce *connEntry) error {
+	r := &connReader{scm: scm, ce: ce}
+	for {
+		buffer := make([]byte, readBufferSize)
+		n, err := r.Read(buffer)
+		if n != 0 {
+			errMsg := scm.handleMessage(ps, ce, buffer[0:n])
+			if errMsg != nil {
+				return errMsg
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readFrames saves each message split by the Framer. It returns io.EOF when connection is closed by the client.
+func (scm *ConnectionMgr) readFrames(ps *PayloadStorage, ce *connEntry) error {
+	maxSize := scm.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+
+	scanner := bufio.NewScanner(&connReader{scm: scm, ce: ce})
+	scanner.Buffer(make([]byte, readBufferSize), maxSize)
+	scanner.Split(scm.Framer.Split)
+	for scanner.Scan() {
+		if msg := scanner.Bytes(); len(msg) > 0 {
+			err := scm.handleMessage(ps, ce, msg)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// handleMessage saves a message received in the connection and writes the response if a Responder is defined.
+func (scm *ConnectionMgr) handleMessage(ps *PayloadStorage, ce *connEntry, msg []byte) error {
+	scm.saveMessage(ps, ce, msg)
+	return scm.respond(ce, msg)
+}
+
+// saveMessage saves a message received in the connection.
+func (scm *ConnectionMgr) saveMessage(ps *PayloadStorage, ce *connEntry, msg []byte) {
+	scm.saveValue(ps, ce, msg, nil)
+}
+
+// saveValue saves a message received in the connection with its decoded representation.
+func (scm *ConnectionMgr) saveValue(ps *PayloadStorage, ce *connEntry, msg []byte, value interface{}) {
+	_, saved := ps.addRecordUntil(scm.stopped, ce.info.ClientID, ce.info.ID, msg, value)
+	if saved {
+		scm.activeConnsMtx.Lock()
+		ce.info.Payload = append(ce.info.Payload, msg...)
+		scm.activeConnsMtx.Unlock()
+	}
+}
+
+// connReader reads from a registered connection updating its counters.
+type connReader struct {
+	scm *ConnectionMgr
+	ce  *connEntry
+}
+
+func (cr *connReader) Read(p []byte) (int, error) {
+	if cr.ce.faults != nil {
+		return cr.ce.faults.read(cr.ce.conn, p, cr.count)
+	}
+
+	n, err := cr.ce.conn.Read(p)
+	cr.count(n)
+	return n, err
+}
+
+// count adds n bytes to the bytes read in the connection.
+func (cr *connReader) count(n int) {
+	if n > 0 {
+		cr.scm.activeConnsMtx.Lock()
+		cr.ce.info.BytesIn += int64(n)
+		cr.scm.activeConnsMtx.Unlock()
+	}
+}
+
+// GetConnections returns the list of connections accepted by the server, active and closed, in accept order.
+func (scm *ConnectionMgr) GetConnections() []ConnectionInfo {
+	scm.activeConnsMtx.Lock()
+	defer scm.activeConnsMtx.Unlock()
+
+	r := make([]ConnectionInfo, len(scm.connLog))
+	for i, ce := range scm.connLog {
+		r[i] = ce.info
+	}
+	return r
+}
+
+// GetConnection returns the connection identified with id. Returned bool is false if connection does not exist.
+func (scm *ConnectionMgr) GetConnection(id uint64) (ConnectionInfo, bool) {
+	scm.activeConnsMtx.Lock()
+	defer scm.activeConnsMtx.Unlock()
+
+	for _, ce := range scm.connLog {
+		if ce.info.ID == id {
+			return ce.info, true
+		}
+	}
+	return ConnectionInfo{}, false
+}
+
+// GetConnectionsByAddress returns the list of connections accepted from the source address in accept order.
+func (scm *ConnectionMgr) GetConnectionsByAddress(remoteAddr string) []ConnectionInfo {
+	scm.activeConnsMtx.Lock()
+	defer scm.activeConnsMtx.Unlock()
+
+	var r []ConnectionInfo
+	for _, ce := range scm.connLog {
+		if ce.info.RemoteAddr == remoteAddr {
+			r = append(r, ce.info)
+		}
+	}
+	return r
+}
+
+// ActiveConnections returns the list of connections that are still open in accept order.
+func (scm *ConnectionMgr) ActiveConnections() []ConnectionInfo {
+	scm.activeConnsMtx.Lock()
+	defer scm.activeConnsMtx.Unlock()
+
+	var r []ConnectionInfo
+	for _, ce := range scm.connLog {
+		if ce.info.Active() {
+			r = append(r, ce.info)
+		}
+	}
+	return r
+}
+
+// TotalConnections returns the number of connections accepted since the server was created.
+func (scm *ConnectionMgr) TotalConnections() int {
+	scm.activeConnsMtx.Lock()
+	defer scm.activeConnsMtx.Unlock()
+	return len(scm.connLog)
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+func ExampleAdmissionReject() {
+	lst := Listener{
+		ConnectionMgr: ConnectionMgr{
+			MaxConnections:  1,
+			AdmissionPolicy: AdmissionReject,
+			RejectBanner:    []byte("BUSY\n"),
+		},
+	}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+	err = lst.WaitForConnections(ctx, 1)
+	if err != nil {
+		panic(err)
+	}
+
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+	banner, err := ioutil.ReadAll(second)
+	fmt.Printf("Rejected with %q, %v\n", banner, err)
+	fmt.Println("Rejected connections", lst.RejectedConnections())
+
+	_ = second.Close()
+	_ = first.Close()
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	//Output:
+	// Rejected with "BUSY\n", <nil>
+	// Rejected connections 1
+}
+
+func ExampleAdmissionHold() {
+	lst := Listener{
+		ConnectionMgr: ConnectionMgr{
+			MaxConnections:  1,
+			AdmissionPolicy: AdmissionHold,
+		},
+	}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+	err = lst.WaitForConnections(ctx, 1)
+	if err != nil {
+		panic(err)
+	}
+
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+	_, err = second.Write([]byte("sent while held"))
+	if err != nil {
+		panic(
+			fmt.Sprintf("while send data to socket: %v", err),
+		)
+	}
+
+	for i := 0; i < 100 && lst.HeldConnections() == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	fmt.Println("Held connections", lst.HeldConnections(), "records", lst.NRecords())
+
+	// Held connection is read when the first one is released
+	_ = first.Close()
+	err = lst.WaitForRecords(ctx, 1)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Held connections", lst.HeldConnections(), "records", lst.NRecords())
+
+	_ = second.Close()
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(string(lst.Records()[0].Data))
+
+	//Output:
+	// Held connections 1 records 0
+	// Held connections 0 records 1
+	// sent while held
+}
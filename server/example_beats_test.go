@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+func ExampleBeatsListener() {
+	lst := BeatsListener{}
+	lst.SkipAck = func(ci ConnectionInfo, seq uint32) bool { return seq == 3 }
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+
+	window := func(n uint32) []byte {
+		frame := []byte{'2', 'W', 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(frame[2:], n)
+		return frame
+	}
+	jsonFrame := func(seq uint32, doc string) []byte {
+		frame := []byte{'2', 'J', 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(frame[2:], seq)
+		binary.BigEndian.PutUint32(frame[6:], uint32(len(doc)))
+		return append(frame, doc...)
+	}
+	send := func(data []byte) {
+		_, err := conn.Write(data)
+		if err != nil {
+			panic(
+				fmt.Sprintf("while send data to socket: %v", err),
+			)
+		}
+	}
+
+	// First window with 2 events in a compressed frame
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	_, _ = zw.Write(jsonFrame(1, `{"message":"first"}`))
+	_, _ = zw.Write(jsonFrame(2, `{"message":"second"}`))
+	_ = zw.Close()
+	frame := []byte{'2', 'C', 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(frame[2:], uint32(compressed.Len()))
+	send(window(2))
+	send(append(frame, compressed.Bytes()...))
+
+	ack := make([]byte, 6)
+	_, err = io.ReadFull(conn, ack)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("ACK %c %d\n", ack[1], binary.BigEndian.Uint32(ack[2:]))
+
+	// Second window, ACK is skipped
+	send(window(1))
+	send(jsonFrame(3, `{"message":"third"}`))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = lst.WaitForRecords(ctx, 3)
+	if err != nil {
+		panic(err)
+	}
+
+	err = conn.Close()
+	if err != nil {
+		panic(
+			fmt.Sprintf("while close client: %v", err),
+		)
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range lst.BeatsRecords() {
+		fmt.Println(r.Seq, r.Event.Seq, r.Event.Compressed, r.Event.Fields["message"])
+	}
+	ci, _ := lst.GetConnection(1)
+	fmt.Println("Bytes out", ci.BytesOut)
+
+	//Output:
+	// ACK A 2
+	// 1 1 true first
+	// 2 2 true second
+	// 3 3 false third
+	// Bytes out 6
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+func ExampleConnectionMgr_GetConnections() {
+	lst := Listener{}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			panic(
+				fmt.Sprintf("while connect to address %s address: %v", addr, err),
+			)
+		}
+		_, err = conn.Write([]byte(fmt.Sprintf("payload of connection %d", i+1)))
+		if err != nil {
+			panic(
+				fmt.Sprintf("while send data to socket: %v", err),
+			)
+		}
+		err = conn.Close()
+		if err != nil {
+			panic(
+				fmt.Sprintf("while close client: %v", err),
+			)
+		}
+		// Wait to ensure connection was accepted and released
+		err = lst.WaitForTotalConnections(ctx, i+1)
+		if err != nil {
+			panic(err)
+		}
+		err = lst.WaitForNoConnections(ctx)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Total connections", lst.TotalConnections())
+	for _, ci := range lst.GetConnections() {
+		fmt.Println(ci.ID, ci.Active(), ci.CloseReason, ci.BytesIn, string(ci.Payload))
+	}
+
+	ci, ok := lst.GetConnection(2)
+	fmt.Println("Connection 2 found", ok, len(lst.GetConnectionsByAddress(ci.RemoteAddr)))
+
+	//Output:
+	// Total connections 3
+	// 1 false EOF 23 payload of connection 1
+	// 2 false EOF 23 payload of connection 2
+	// 3 false EOF 23 payload of connection 3
+	// Connection 2 found true 1
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func ExampleFaultPlan() {
+	lst := Listener{}
+	lst.Faults = &FaultPlan{
+		Seed:        1,
+		RefuseFirst: 1,
+		Rules: []FaultRule{
+			{Action: FaultClose, Connections: []uint64{3}, AfterBytes: 5},
+		},
+	}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+
+	// First connection is reset by the server just after it is accepted, reset can be received while dial
+	conn, err := net.Dial("tcp", addr)
+	if err == nil {
+		err = conn.SetReadDeadline(time.Now().Add(time.Second))
+		if err != nil {
+			panic(err)
+		}
+		_, err = conn.Read(make([]byte, 1))
+		errClose := conn.Close()
+		if errClose != nil {
+			panic(
+				fmt.Sprintf("while close client: %v", errClose),
+			)
+		}
+	}
+	fmt.Println("Refused with reset:", errors.Is(err, syscall.ECONNRESET))
+
+	for i := 1; i < 3; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			panic(
+				fmt.Sprintf("while connect to address %s address: %v", addr, err),
+			)
+		}
+		_, err = conn.Write([]byte(fmt.Sprintf("payload of connection %d", i+1)))
+		if err != nil {
+			panic(
+				fmt.Sprintf("while send data to socket: %v", err),
+			)
+		}
+		err = conn.Close()
+		if err != nil {
+			panic(
+				fmt.Sprintf("while close client: %v", err),
+			)
+		}
+		// Wait to ensure connection was accepted and released
+		err = lst.WaitForTotalConnections(ctx, i+1)
+		if err != nil {
+			panic(err)
+		}
+		err = lst.WaitForNoConnections(ctx)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, ci := range lst.GetConnections() {
+		fmt.Printf("%d %s %d %q\n", ci.ID, ci.CloseReason, ci.BytesIn, ci.Payload)
+		for _, fe := range ci.Faults {
+			fmt.Println("  fault", fe.Action, "at", fe.AtBytes)
+		}
+	}
+
+	//Output:
+	// Refused with reset: true
+	// 1 fault 0 ""
+	//   fault refuse at 0
+	// 2 EOF 23 "payload of connection 2"
+	// 3 fault 5 "paylo"
+	//   fault close at 5
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/cyberluisda/saverserver-go/server/internal/msgpack"
+)
+
+func ExampleForwardListener() {
+	lst := ForwardListener{}
+	lst.SharedKey = "secret"
+	lst.SelfHostname = "collector"
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+	reader := bufio.NewReader(conn)
+
+	send := func(v interface{}) {
+		data, err := msgpack.Append(nil, v)
+		if err != nil {
+			panic(err)
+		}
+		_, err = conn.Write(data)
+		if err != nil {
+			panic(
+				fmt.Sprintf("while send data to socket: %v", err),
+			)
+		}
+	}
+	receive := func() []interface{} {
+		data, err := msgpack.ReadObject(reader, 1024)
+		if err != nil {
+			panic(err)
+		}
+		v, _, err := msgpack.Decode(data)
+		if err != nil {
+			panic(err)
+		}
+		return v.([]interface{})
+	}
+
+	// Handshake
+	helo := receive()
+	nonce := helo[1].(map[string]interface{})["nonce"].([]byte)
+	send([]interface{}{"PING", "sender", "salt", sha512Hex("salt", "sender", string(nonce), "secret"), "", ""})
+	pong := receive()
+	fmt.Println(pong[0], pong[1], pong[3])
+
+	// Message mode with ack request
+	ts := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC).Unix()
+	send([]interface{}{"app.access", ts, map[string]interface{}{"path": "/"}, map[string]interface{}{"chunk": "c1"}})
+	ack, err := msgpack.ReadObject(reader, 1024)
+	if err != nil {
+		panic(err)
+	}
+	v, _, _ := msgpack.Decode(ack)
+	fmt.Println("Ack", v.(map[string]interface{})["ack"])
+
+	// CompressedPackedForward mode
+	var entries []byte
+	for i := 0; i < 2; i++ {
+		entries, err = msgpack.Append(entries, []interface{}{ts + int64(i), map[string]interface{}{"n": i}})
+		if err != nil {
+			panic(err)
+		}
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, _ = gz.Write(entries)
+	_ = gz.Close()
+	send([]interface{}{"app.metrics", compressed.Bytes(), map[string]interface{}{"compressed": "gzip"}})
+
+	err = conn.Close()
+	if err != nil {
+		panic(
+			fmt.Sprintf("while close client: %v", err),
+		)
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range lst.ForwardRecords() {
+		fmt.Println(r.Seq, r.Entry.Mode, r.Entry.Tag, r.Entry.Time.UTC().Format(time.RFC3339), r.Entry.Record)
+	}
+
+	//Output:
+	// PONG true collector
+	// Ack c1
+	// 1 Message app.access 2021-01-02T03:04:05Z map[path:/]
+	// 2 CompressedPackedForward app.metrics 2021-01-02T03:04:05Z map[n:0]
+	// 3 CompressedPackedForward app.metrics 2021-01-02T03:04:06Z map[n:1]
+}
+
+func ExampleForwardListener_compressed_limit() {
+	lst := ForwardListener{}
+	lst.MaxMessageSize = 128
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+
+	// The entry is compressed to a few bytes but it is bigger than MaxMessageSize once it is decompressed
+	entries, err := msgpack.Append(nil, []interface{}{int64(0), map[string]interface{}{"log": strings.Repeat("a", 1024)}})
+	if err != nil {
+		panic(err)
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, _ = gz.Write(entries)
+	_ = gz.Close()
+	data, err := msgpack.Append(nil, []interface{}{"app", compressed.Bytes(), map[string]interface{}{"compressed": "gzip"}})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Message smaller than MaxMessageSize:", len(data) <= lst.MaxMessageSize)
+	_, err = conn.Write(data)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while send data to socket: %v", err),
+		)
+	}
+
+	// The listener closes the connection
+	_, err = conn.Read(make([]byte, 1))
+	fmt.Println("Read error:", err)
+	err = conn.Close()
+	if err != nil {
+		panic(
+			fmt.Sprintf("while close client: %v", err),
+		)
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Records", len(lst.ForwardRecords()))
+	for _, ci := range lst.GetConnections() {
+		fmt.Println(ci.ID, ci.CloseReason, errors.Is(ci.Err, ErrInvalidFrame))
+	}
+
+	//Output:
+	// Message smaller than MaxMessageSize: true
+	// Read error: EOF
+	// Records 0
+	// 1 error true
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+func ExampleLineFramer() {
+	lst := Listener{
+		ConnectionMgr: ConnectionMgr{
+			Framer: LineFramer{},
+		},
+	}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+
+	// messages are split between writes on purpose
+	for _, s := range []string{"first mes", "sage\nsecond message\r\nthird", " message"} {
+		_, err = conn.Write([]byte(s))
+		if err != nil {
+			panic(
+				fmt.Sprintf("while send data to socket: %v", err),
+			)
+		}
+	}
+
+	err = conn.Close()
+	if err != nil {
+		panic(
+			fmt.Sprintf("while close client: %v", err),
+		)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = lst.WaitForRecords(ctx, 3) // Wait to ensure data was received
+	if err != nil {
+		panic(err)
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range lst.Records() {
+		fmt.Printf("%d %q\n", r.Seq, r.Data)
+	}
+
+	//Output:
+	// 1 "first message"
+	// 2 "second message"
+	// 3 "third message"
+}
+
+func ExampleOctetCountingFramer() {
+	scanner := bufio.NewScanner(strings.NewReader("11 first event12 second event"))
+	scanner.Split(OctetCountingFramer{}.Split)
+	for scanner.Scan() {
+		fmt.Printf("%q\n", scanner.Text())
+	}
+	fmt.Println("Error:", scanner.Err())
+
+	scanner = bufio.NewScanner(strings.NewReader("11 first event12 second"))
+	scanner.Split(OctetCountingFramer{}.Split)
+	for scanner.Scan() {
+		fmt.Printf("%q\n", scanner.Text())
+	}
+	fmt.Println("Error:", scanner.Err())
+
+	//Output:
+	// "first event"
+	// "second event"
+	// Error: <nil>
+	// "first event"
+	// Error: truncated frame
+}
+
+func ExampleLengthPrefixFramer() {
+	data := []byte{0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o', 0, 0, 0, 3, 'b', 'y', 'e'}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Split(LengthPrefixFramer{Size: 4}.Split)
+	for scanner.Scan() {
+		fmt.Printf("%q\n", scanner.Text())
+	}
+
+	//Output:
+	// "hello"
+	// "bye"
+}
+
+func ExampleDelimiterFramer() {
+	scanner := bufio.NewScanner(strings.NewReader("one||two||three"))
+	scanner.Split(DelimiterFramer{Delimiter: []byte("||")}.Split)
+	for scanner.Scan() {
+		fmt.Printf("%q\n", scanner.Text())
+	}
+
+	//Output:
+	// "one"
+	// "two"
+	// "three"
+}
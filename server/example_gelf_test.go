@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+func ExampleGELFListenerPacket() {
+	lst := GELFListenerPacket{}
+	lst.MaxDecompressedSize = 100
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "udp://")
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, _ = gz.Write([]byte(`{"version":"1.1","host":"web1","short_message":"chunked","level":3,"_app":"shop"}`))
+	_ = gz.Close()
+
+	chunk := func(id byte, seq, count int, data []byte) []byte {
+		header := []byte{0x1e, 0x0f, 0, 0, 0, 0, 0, 0, 0, id, byte(seq), byte(count)}
+		return append(header, data...)
+	}
+	half := compressed.Len() / 2
+
+	var big bytes.Buffer
+	gz = gzip.NewWriter(&big)
+	_, _ = gz.Write([]byte(`{"version":"1.1","host":"web3","short_message":"` + strings.Repeat("x", 100) + `"}`))
+	_ = gz.Close()
+	datagrams := [][]byte{
+		// Chunks of message 1 out of order
+		chunk(1, 1, 2, compressed.Bytes()[half:]),
+		chunk(1, 0, 2, compressed.Bytes()[0:half]),
+		// First chunk of message 2 only
+		chunk(2, 0, 3, []byte(`{"version":"1.1",`)),
+		// Not chunked and not compressed
+		[]byte(`{"version":"1.1","host":"web2","short_message":"plain"}`),
+		// Bigger than MaxDecompressedSize when it is decompressed
+		big.Bytes(),
+	}
+	for _, d := range datagrams {
+		_, err = conn.Write(d)
+		if err != nil {
+			panic(
+				fmt.Sprintf("while send data to socket: %v", err),
+			)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = lst.WaitForRecords(ctx, 3)
+	if err != nil {
+		panic(err)
+	}
+
+	err = conn.Close()
+	if err != nil {
+		panic(
+			fmt.Sprintf("while close client: %v", err),
+		)
+	}
+
+	// Incomplete messages are saved as expired on stop
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range lst.GELFRecords() {
+		m := r.Message
+		if m.Expired {
+			fmt.Println(r.Seq, "expired", m.MessageID, m.ChunksReceived, "of", m.Chunks, string(r.Data))
+			continue
+		}
+		if m.Err != nil {
+			fmt.Println(r.Seq, "error", m.Err)
+			continue
+		}
+		fmt.Println(r.Seq, m.Host, m.ShortMessage, m.Level, m.Fields["_app"], m.Chunks)
+	}
+
+	//Output:
+	// 1 web1 chunked 3 shop 2
+	// 2 web2 plain 1 <nil> 0
+	// 3 error invalid frame: decompressed GELF message is bigger than 100 bytes
+	// 4 expired 0000000000000002 1 of 3 {"version":"1.1",
+}
+
+func ExampleGELFListener() {
+	lst := GELFListener{}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+
+	_, err = conn.Write([]byte(
+		`{"version":"1.1","host":"db1","short_message":"first"}` + "\x00" +
+			`{"version":"1.1","short_message":"without host"}` + "\x00",
+	))
+	if err != nil {
+		panic(
+			fmt.Sprintf("while send data to socket: %v", err),
+		)
+	}
+
+	err = conn.Close()
+	if err != nil {
+		panic(
+			fmt.Sprintf("while close client: %v", err),
+		)
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range lst.GELFRecords() {
+		fmt.Println(r.Seq, r.Message.ShortMessage, r.Message.Err)
+	}
+
+	//Output:
+	// 1 first <nil>
+	// 2 without host invalid frame: GELF message without host or short_message
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+func ExampleHECEndpoint() {
+	hec := &HECEndpoint{
+		Tokens: []string{"my-token"},
+		Ack:    true,
+		// Second event of each request fails
+		Reject: func(n int, event *HECEvent) bool { return n == 1 },
+	}
+	lst := HTTPListener{Routes: hec.Routes()}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	post := func(path, token, body string) {
+		req, err := http.NewRequest(http.MethodPost, lst.URL()+path, strings.NewReader(body))
+		if err != nil {
+			panic(err)
+		}
+		req.Header.Set("Authorization", "Splunk "+token)
+		req.Header.Set("X-Splunk-Request-Channel", "11111111-2222-3333-4444-555555555555")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(resp.StatusCode, string(respBody))
+	}
+
+	post(HECEventPath, "bad-token", `{"event":"ignored"}`)
+	post(HECEventPath, "my-token", `{"event":"first","sourcetype":"app"}`)
+	post(HECEventPath, "my-token", `{"event":{"msg":"second"},"host":"h1"}{"event":"third"}`)
+	post(HECEventPath, "my-token", `{"event":"fourth"}`)
+	post(HECAckPath, "my-token", `{"acks":[0,1,2]}`)
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range lst.HECRecords() {
+		fmt.Println(r.Seq, r.Event.AckID, r.Event.Event, string(r.Data))
+	}
+
+	//Output:
+	// 403 {"text":"Invalid token","code":4}
+	// 200 {"text":"Success","code":0,"ackId":0}
+	// 503 {"text":"Server is busy","code":9,"invalid-event-number":1}
+	// 200 {"text":"Success","code":0,"ackId":1}
+	// 200 {"acks":{"0":true,"1":true,"2":false}}
+	// 1 0 first {"event":"first","sourcetype":"app"}
+	// 2 1 fourth {"event":"fourth"}
+}
+
+func ExampleBulkEndpoint() {
+	bulk := &BulkEndpoint{
+		Fail: func(n int, item *BulkItem) int {
+			if item.ID == "2" {
+				return http.StatusTooManyRequests
+			}
+			return 0
+		},
+	}
+	lst := HTTPListener{Routes: bulk.Routes()}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	body := `{"index":{"_id":"1"}}
+{"msg":"first"}
+{"create":{"_index":"other","_id":"2"}}
+{"msg":"second"}
+{"delete":{"_id":"3"}}
+`
+	resp, err := http.Post(lst.URL()+"/logs/_bulk", "application/x-ndjson", strings.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	resp.Body.Close()
+	fmt.Println(resp.StatusCode, string(respBody))
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range lst.BulkRecords() {
+		fmt.Println(r.Seq, r.Item.Action, r.Item.Index, r.Item.ID, string(r.Data))
+	}
+
+	//Output:
+	// 200 {"took":0,"errors":true,"items":[{"index":{"_index":"logs","_id":"1","status":201,"result":"created"}},{"create":{"_index":"other","_id":"2","status":429,"error":{"type":"es_rejected_execution_exception","reason":"Too Many Requests"}}},{"delete":{"_index":"logs","_id":"3","status":200,"result":"deleted"}}]}
+	// 1 index logs 1 {"msg":"first"}
+	// 2 delete logs 3 {"delete":{"_id":"3"}}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+func ExampleHTTPListener() {
+	lst := HTTPListener{
+		Routes: []HTTPRoute{
+			{Method: http.MethodPost, Path: "/hooks/*", Response: HTTPResponse{
+				Status: http.StatusAccepted,
+				Body:   []byte(`{"result":"accepted"}`),
+			}},
+		},
+		DefaultResponse: HTTPResponse{Status: http.StatusNotFound},
+		DecodeGzip:      true,
+	}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	_, err = gz.Write([]byte("compressed event"))
+	if err != nil {
+		panic(err)
+	}
+	err = gz.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, lst.URL()+"/hooks/deploy?env=prod", &body)
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("X-Token", "secret")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	resp.Body.Close()
+	fmt.Println(resp.StatusCode, string(respBody))
+
+	resp, err = http.Get(lst.URL() + "/unknown")
+	if err != nil {
+		panic(err)
+	}
+	resp.Body.Close()
+	fmt.Println(resp.StatusCode)
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range lst.HTTPRecords() {
+		fmt.Printf("%d %s %s %q %q %q\n", r.Seq, r.Request.Method, r.Request.Path, r.Request.Query.Get("env"),
+			r.Request.Header.Get("X-Token"), r.Data)
+	}
+	ci, _ := lst.GetConnection(1)
+	fmt.Println("Connections", lst.TotalConnections(), ci.CloseReason)
+
+	//Output:
+	// 202 {"result":"accepted"}
+	// 404
+	// 1 POST /hooks/deploy "prod" "secret" "compressed event"
+	// 2 GET /unknown "" "" ""
+	// Connections 1 server stop
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func ExampleTLSListener_IdentityFunc() {
+	lst, bundle, err := NewEphemeralTLSListener()
+	if err != nil {
+		panic(err)
+	}
+	lst.ClientAuth = tls.RequireAndVerifyClientCert
+	lst.IdentityFunc = FingerprintIdentity
+	err = lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	alice, err := bundle.Intermediate.NewClientCert("alice")
+	if err != nil {
+		panic(err)
+	}
+	bob, err := bundle.Intermediate.NewClientCert("bob")
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	messages := []struct {
+		cert string
+		msg  string
+	}{{"alice", "first "}, {"bob", "hello"}, {"alice", "second"}}
+	for n, m := range messages {
+		kp := alice
+		if m.cert == "bob" {
+			kp = bob
+		}
+		cert, err := kp.TLSCertificate()
+		if err != nil {
+			panic(err)
+		}
+		cfg := bundle.ClientTLSConfig()
+		cfg.Certificates = []tls.Certificate{cert}
+		conn, err := tls.Dial("tcp", addr, cfg)
+		if err != nil {
+			panic(
+				fmt.Sprintf("while connect to address %s address: %v", addr, err),
+			)
+		}
+		_, err = conn.Write([]byte(m.msg))
+		if err != nil {
+			panic(
+				fmt.Sprintf("while send data to socket: %v", err),
+			)
+		}
+		_ = conn.Close()
+
+		// Keep the order of the messages
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err = lst.WaitForRecords(ctx, n+1)
+		cancel()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	id := lst.GetConnections()[0].Identity
+	fmt.Println("Subject:", id.Subject.CommonName)
+	for _, issuer := range id.Issuers {
+		fmt.Println("Issuer:", issuer.CommonName)
+	}
+	fmt.Println("Fingerprint:", id.Fingerprint == CertFingerprint(alice.Certificate))
+	fmt.Println("Legacy key:", strings.TrimSuffix(LegacyIdentity(id), id.RemoteAddr))
+
+	fmt.Println("#Items", lst.NPayloadItems())
+	for key, payload := range lst.GetPayloadsByCertCN("alice") {
+		fmt.Printf("alice %v %q\n", key == id.Fingerprint, payload)
+	}
+	// Fingerprint in openssl format
+	var fp []string
+	for i := 0; i < len(id.Fingerprint); i += 2 {
+		fp = append(fp, strings.ToUpper(id.Fingerprint[i:i+2]))
+	}
+	for key, payload := range lst.GetPayloadsByFingerprint(strings.Join(fp, ":")) {
+		fmt.Printf("fingerprint %v %q\n", key == id.Fingerprint, payload)
+	}
+	fmt.Println("Unknown", len(lst.GetPayloadsByCertCN("carol")))
+
+	//Output:
+	// Subject: alice
+	// Issuer: saverserver Intermediate CA
+	// Issuer: saverserver Root CA
+	// Fingerprint: true
+	// Legacy key: CN=saverserver Intermediate CA-CN=alice@
+	// #Items 2
+	// alice true "first second"
+	// fingerprint true "first second"
+	// Unknown 0
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func ExampleConnectionMgr_Shutdown() {
+	lst := Listener{}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("client never closes the connection"))
+	if err != nil {
+		panic(
+			fmt.Sprintf("while send data to socket: %v", err),
+		)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	err = lst.WaitForRecords(ctx, 1)
+	if err != nil {
+		panic(err)
+	}
+
+	// Connection is forcibly closed when ctx is done
+	err = lst.Shutdown(ctx)
+	fmt.Println("Shutdown error:", err)
+	fmt.Println("Connections after shutdown", lst.Connections())
+
+	ci, _ := lst.GetConnection(1)
+	fmt.Println("Close reason:", ci.CloseReason)
+	fmt.Println(string(ci.Payload))
+
+	//Output:
+	// Shutdown error: context deadline exceeded
+	// Connections after shutdown 0
+	// Close reason: server stop
+	// client never closes the connection
+}
+
+func ExampleConnectionMgr_Shutdown_refused() {
+	lst := Listener{}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = lst.WaitForConnections(ctx, 1)
+	if err != nil {
+		panic(err)
+	}
+
+	// Shutdown waits for the open connection, new connections are refused meanwhile
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- lst.Shutdown(ctx)
+	}()
+
+	for {
+		c, err := net.Dial("tcp", addr)
+		if errors.Is(err, syscall.ECONNRESET) {
+			// Listener was closed while connection was queued
+			continue
+		}
+		if err != nil {
+			fmt.Println("Dial refused:", errors.Is(err, syscall.ECONNREFUSED))
+			break
+		}
+		err = c.Close()
+		if err != nil {
+			panic(
+				fmt.Sprintf("while close client: %v", err),
+			)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	err = conn.Close()
+	if err != nil {
+		panic(
+			fmt.Sprintf("while close client: %v", err),
+		)
+	}
+	fmt.Println("Shutdown error:", <-shutdown)
+
+	//Output:
+	// Dial refused: true
+	// Shutdown error: <nil>
+}
+
+func ExampleListener_StartContext() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	lst := Listener{}
+	err := lst.StartContext(ctx)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Accepting after start", lst.Accepting())
+
+	cancel()
+	for i := 0; i < 100 && lst.Accepting(); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	fmt.Println("Accepting after context is cancelled", lst.Accepting())
+
+	//Output:
+	// Accepting after start true
+	// Accepting after context is cancelled false
+}
+
+func ExampleListenerPacket_Shutdown() {
+	lp := ListenerPacket{}
+	err := lp.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	conn, err := net.Dial("udp", strings.TrimPrefix(lp.GetAddress(), "udp://"))
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", lp.GetAddress(), err),
+		)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 100; i++ {
+		_, err = conn.Write([]byte("x"))
+		if err != nil {
+			panic(
+				fmt.Sprintf("while send data to socket: %v", err),
+			)
+		}
+	}
+
+	// Datagrams queued in the socket are read before close it
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = lp.Shutdown(ctx)
+	fmt.Println("Shutdown error:", err)
+	fmt.Println("#Records", lp.NRecords())
+
+	//Output:
+	// Shutdown error: <nil>
+	// #Records 100
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/cyberluisda/saverserver-go/server/internal/protowire"
+)
+
+func ExampleOTLPEndpoint() {
+	otlp := &OTLPEndpoint{
+		// Debug logs are rejected
+		Reject: func(n int, item interface{}) bool {
+			l, ok := item.(*OTLPLog)
+			return ok && l.SeverityText == "DEBUG"
+		},
+	}
+	lst := HTTPListener{Routes: otlp.Routes()}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	post := func(path, contentType string, body []byte) {
+		resp, err := http.Post(lst.URL()+path, contentType, bytes.NewReader(body))
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%d %q\n", resp.StatusCode, respBody)
+	}
+
+	post(OTLPLogsPath, "application/json", []byte(`{"resourceLogs":[{
+		"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"shop"}}]},
+		"scopeLogs":[{"scope":{"name":"checkout"},"logRecords":[
+			{"timeUnixNano":"1700000000000000000","severityNumber":9,"severityText":"INFO",
+			 "body":{"stringValue":"order placed"},
+			 "attributes":[{"key":"order","value":{"intValue":"42"}}],
+			 "traceId":"5B8EFFF798038103D269B633813FC60C"},
+			{"severityText":"DEBUG","body":{"stringValue":"cart details"}}
+		]}]}]}`))
+
+	// ExportTraceServiceRequest with a span encoded with protobuf
+	attr := protowire.AppendBytes(nil, 1, []byte("http.method"))
+	attr = protowire.AppendBytes(attr, 2, protowire.AppendBytes(nil, 1, []byte("GET")))
+	span := protowire.AppendBytes(nil, 1, []byte{0x5b, 0x8e, 0xff, 0xf7, 0x98, 0x03, 0x81, 0x03, 0xd2, 0x69, 0xb6, 0x33, 0x81, 0x3f, 0xc6, 0x0c})
+	span = protowire.AppendBytes(span, 2, []byte{0xee, 0xe1, 0x9b, 0x7e, 0xc3, 0xc1, 0xb1, 0x74})
+	span = protowire.AppendBytes(span, 5, []byte("GET /orders"))
+	span = protowire.AppendVarint(span, 6, 2)
+	span = protowire.AppendBytes(span, 9, attr)
+	scopeSpans := protowire.AppendBytes(nil, 2, span)
+	resourceSpans := protowire.AppendBytes(nil, 2, scopeSpans)
+	post(OTLPTracesPath, "application/x-protobuf", protowire.AppendBytes(nil, 1, resourceSpans))
+
+	post(OTLPMetricsPath, "application/json", []byte(`{"resourceMetrics":[{"scopeMetrics":[{"metrics":[
+		{"name":"http.requests","unit":"1","sum":{"aggregationTemporality":2,"isMonotonic":true,"dataPoints":[
+			{"asInt":"7","attributes":[{"key":"code","value":{"intValue":200}}]},
+			{"asInt":"1","attributes":[{"key":"code","value":{"intValue":500}}]}]}},
+		{"name":"http.duration","histogram":{"dataPoints":[
+			{"count":"3","sum":0.7,"bucketCounts":["1","2"],"explicitBounds":[0.25]}]}}
+	]}]}]}`))
+
+	post(OTLPLogsPath, "text/plain", []byte("hello"))
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range lst.OTLPExportRecords() {
+		e := r.Export
+		fmt.Println(r.Seq, e.Signal, e.Encoding, e.Status, e.Items, e.Rejected)
+	}
+	for _, r := range lst.OTLPLogRecords() {
+		l := r.Log
+		fmt.Println(r.Seq, l.Resource["service.name"], l.Scope.Name, l.Time.Unix(), l.SeverityText, l.Body,
+			l.Attributes, l.TraceID)
+	}
+	for _, r := range lst.OTLPSpanRecords() {
+		s := r.Span
+		fmt.Println(r.Seq, s.Name, s.Kind, s.TraceID, s.SpanID, s.Attributes)
+	}
+	for _, r := range lst.OTLPDataPointRecords() {
+		p := r.DataPoint
+		fmt.Println(r.Seq, p.Metric, p.Type, p.Value, p.Monotonic, p.Attributes, p.Count, p.Sum, p.BucketCounts,
+			p.ExplicitBounds)
+	}
+
+	//Output:
+	// 200 "{\"partialSuccess\":{\"errorMessage\":\"1 of 2 items rejected\",\"rejectedLogRecords\":\"1\"}}"
+	// 200 ""
+	// 200 "{}"
+	// 415 "{\"code\":2,\"message\":\"unsupported content type text/plain\"}"
+	// 1 logs json 200 2 1
+	// 3 traces protobuf 200 1 0
+	// 5 metrics json 200 3 0
+	// 2 shop checkout 1700000000 INFO order placed map[order:42] 5b8efff798038103d269b633813fc60c
+	// 4 GET /orders 2 5b8efff798038103d269b633813fc60c eee19b7ec3c1b174 map[http.method:GET]
+	// 6 http.requests sum 7 true map[code:200] 0 0 [] []
+	// 7 http.requests sum 1 true map[code:500] 0 0 [] []
+	// 8 http.duration histogram 0 false map[] 3 0.7 [1 2] [0.25]
+}
+
+func ExampleOTLPEndpoint_fail() {
+	failures := 0
+	otlp := &OTLPEndpoint{
+		// First request is throttled
+		Fail: func(export *OTLPExport) int {
+			if failures == 0 {
+				failures++
+				return http.StatusTooManyRequests
+			}
+			return 0
+		},
+		RetryAfter: 2 * time.Second,
+	}
+	lst := HTTPListener{Routes: otlp.Routes()}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	logRecord := protowire.AppendBytes(nil, 5, protowire.AppendBytes(nil, 1, []byte("disk full")))
+	scopeLogs := protowire.AppendBytes(nil, 2, logRecord)
+	resourceLogs := protowire.AppendBytes(nil, 2, scopeLogs)
+	body := protowire.AppendBytes(nil, 1, resourceLogs)
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Post(lst.URL()+OTLPLogsPath, "application/x-protobuf", bytes.NewReader(body))
+		if err != nil {
+			panic(err)
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%d %q %q\n", resp.StatusCode, resp.Header.Get("Retry-After"), respBody)
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range lst.OTLPExportRecords() {
+		fmt.Println(r.Seq, r.Export.Status, r.Export.Items)
+	}
+	for _, r := range lst.OTLPLogRecords() {
+		fmt.Println(r.Seq, r.Log.Body)
+	}
+
+	//Output:
+	// 429 "2" "\b\b\x12\x11Too Many Requests"
+	// 200 "" ""
+	// 1 429 1
+	// 2 200 1
+	// 3 disk full
+}
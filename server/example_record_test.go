@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+func ExamplePayloadStorage_Records() {
+	lp := ListenerPacket{}
+	err := lp.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", strings.TrimPrefix(lp.GetAddress(), "udp://"))
+	if err != nil {
+		panic(
+			fmt.Sprintf("while resolve address: %v", err),
+		)
+	}
+
+	// Two clients sending data alternately
+	clients := make([]*net.UDPConn, 2)
+	for i := range clients {
+		clients[i], err = net.DialUDP("udp", nil, addr)
+		if err != nil {
+			panic(
+				fmt.Sprintf("while connect to address %s address: %v", addr, err),
+			)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		c := clients[i%2]
+		_, err = c.Write([]byte(fmt.Sprintf("message %d", i)))
+		if err != nil {
+			panic(
+				fmt.Sprintf("while send data to socket: %v", err),
+			)
+		}
+		time.Sleep(time.Millisecond * 10) // Ensure arrival order
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = lp.WaitForRecords(ctx, 4) // Wait to ensure data was received
+	if err != nil {
+		panic(err)
+	}
+
+	err = lp.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range lp.Records() {
+		fmt.Println(r.Seq, string(r.Data), r.RemoteAddr == clients[(r.Seq-1)%2].LocalAddr().String())
+	}
+	fmt.Println("Records from first client", len(lp.RecordsFor(clients[0].LocalAddr().String())))
+	fmt.Println("Records since 3", len(lp.RecordsSince(3)))
+
+	for _, c := range clients {
+		_ = c.Close()
+	}
+
+	//Output:
+	// 1 message 0 true
+	// 2 message 1 true
+	// 3 message 2 true
+	// 4 message 3 true
+	// Records from first client 2
+	// Records since 3 1
+}
+
+func ExampleListener_Records() {
+	lst := Listener{}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			panic(
+				fmt.Sprintf("while connect to address %s address: %v", addr, err),
+			)
+		}
+		_, err = conn.Write([]byte(fmt.Sprintf("connection %d", i)))
+		if err != nil {
+			panic(
+				fmt.Sprintf("while send data to socket: %v", err),
+			)
+		}
+		err = conn.Close()
+		if err != nil {
+			panic(
+				fmt.Sprintf("while close client: %v", err),
+			)
+		}
+		err = lst.WaitForRecords(ctx, i+1) // Wait to ensure data was received
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range lst.Records() {
+		fmt.Println(r.Seq, r.ConnID, string(r.Data))
+	}
+
+	//Output:
+	// 1 1 connection 0
+	// 2 2 connection 1
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+func ExampleRELPListener() {
+	lst := RELPListener{}
+	lst.Response = RELPNackTransactions(3)
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+	reader := bufio.NewReader(conn)
+
+	send := func(txnr int, command, data string) {
+		frame := fmt.Sprintf("%d %s %d", txnr, command, len(data))
+		if len(data) > 0 {
+			frame += " " + data
+		}
+		_, err := fmt.Fprintf(conn, "%s\n", frame)
+		if err != nil {
+			panic(
+				fmt.Sprintf("while send data to socket: %v", err),
+			)
+		}
+	}
+
+	readRsp := func() string {
+		var txnr, dataLen int
+		var command string
+		_, err := fmt.Fscanf(reader, "%d %s %d ", &txnr, &command, &dataLen)
+		if err != nil {
+			panic(err)
+		}
+		data := make([]byte, dataLen+1) // data and trailer
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			panic(err)
+		}
+		return fmt.Sprintf("%d %s %q", txnr, command, strings.SplitN(string(data), "\n", 2)[0])
+	}
+
+	send(1, "open", "relp_version=0\nrelp_software=example\ncommands=syslog")
+	fmt.Println(readRsp())
+	send(2, "syslog", "<13>Oct 11 22:14:15 host app: first message")
+	fmt.Println(readRsp())
+	send(3, "syslog", "<13>Oct 11 22:14:16 host app: second message")
+	fmt.Println(readRsp())
+	send(4, "syslog", "<13>Oct 11 22:14:17 host app: second message")
+	fmt.Println(readRsp())
+	// Transaction number is duplicated, it is recorded in the message
+	send(4, "syslog", "<13>Oct 11 22:14:18 host app: third message")
+	fmt.Println(readRsp())
+	send(5, "close", "")
+	fmt.Println(readRsp())
+
+	err = conn.Close()
+	if err != nil {
+		panic(
+			fmt.Sprintf("while close client: %v", err),
+		)
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range lst.RELPRecords() {
+		fmt.Println(r.Seq, r.Message.Txnr, r.Message.Response, r.Message.Err)
+	}
+	for _, r := range lst.SyslogRecords() {
+		fmt.Println(r.Seq, r.Message.Message)
+	}
+	ci, _ := lst.GetConnection(1)
+	fmt.Println("Close reason", ci.CloseReason)
+
+	//Output:
+	// 1 rsp "200 OK"
+	// 2 rsp "200 OK"
+	// 3 rsp "500 message rejected"
+	// 4 rsp "200 OK"
+	// 4 rsp "200 OK"
+	// 5 rsp "200 OK"
+	// 1 2 ack <nil>
+	// 2 3 nack <nil>
+	// 3 4 ack <nil>
+	// 4 4 ack duplicate RELP transaction number: 4
+	// 1 first message
+	// 2 second message
+	// 3 second message
+	// 4 third message
+	// Close reason EOF
+}
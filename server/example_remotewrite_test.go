@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/cyberluisda/saverserver-go/server/internal/protowire"
+	"github.com/cyberluisda/saverserver-go/server/internal/snappy"
+)
+
+func ExampleRemoteWriteEndpoint() {
+	requests := 0
+	rw := &RemoteWriteEndpoint{
+		// First request is throttled
+		Fail: func(req *RemoteWriteRequest) int {
+			requests++
+			if requests == 1 {
+				return http.StatusTooManyRequests
+			}
+			return 0
+		},
+		RetryAfter: 5 * time.Second,
+	}
+	lst := HTTPListener{Routes: rw.Routes()}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	// series encodes a TimeSeries message with a sample
+	series := func(value float64, ts int64, labels ...string) []byte {
+		var msg []byte
+		for i := 0; i < len(labels); i += 2 {
+			label := protowire.AppendBytes(nil, 1, []byte(labels[i]))
+			label = protowire.AppendBytes(label, 2, []byte(labels[i+1]))
+			msg = protowire.AppendBytes(msg, 1, label)
+		}
+		sample := protowire.AppendFixed64(nil, 1, math.Float64bits(value))
+		sample = protowire.AppendVarint(sample, 2, uint64(ts))
+		return protowire.AppendBytes(msg, 2, sample)
+	}
+	write := func(timeseries ...[]byte) {
+		var msg []byte
+		for _, ts := range timeseries {
+			msg = protowire.AppendBytes(msg, 1, ts)
+		}
+		req, err := http.NewRequest(http.MethodPost, lst.URL()+RemoteWritePath, bytes.NewReader(snappy.Encode(msg)))
+		if err != nil {
+			panic(err)
+		}
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			panic(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%d %q %q\n", resp.StatusCode, resp.Header.Get("Retry-After"), body)
+	}
+
+	first := [][]byte{
+		series(10, 1000, "__name__", "http_requests_total", "code", "200"),
+		series(2, 1000, "__name__", "http_requests_total", "code", "500"),
+		series(0.5, 1000, "__name__", "up", "job", "api"),
+	}
+	write(first...)
+	// Retry after the throttling
+	write(first...)
+	write(
+		series(12, 2000, "__name__", "http_requests_total", "code", "200"),
+		series(3, 2000, "__name__", "http_requests_total", "code", "503"),
+	)
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range lst.RemoteWriteRequestRecords() {
+		fmt.Println(r.Seq, r.Request.Status, r.Request.Series, r.Request.Samples)
+	}
+
+	found, err := lst.Series(
+		LabelMatcher{Name: "__name__", Value: "http_requests_total"},
+		LabelMatcher{Name: "code", Type: MatchRegexp, Value: "5.."},
+	)
+	if err != nil {
+		panic(err)
+	}
+	for _, s := range found {
+		fmt.Println(s.Labels, s.Samples)
+	}
+
+	found, err = lst.Series(LabelMatcher{Name: "code", Value: "200"})
+	if err != nil {
+		panic(err)
+	}
+	for _, s := range found {
+		fmt.Println(s.Labels, s.Samples)
+	}
+
+	//Output:
+	// 429 "5" "Too Many Requests\n"
+	// 204 "" ""
+	// 204 "" ""
+	// 1 429 3 3
+	// 2 204 3 3
+	// 6 204 2 2
+	// map[__name__:http_requests_total code:500] [{2 1000}]
+	// map[__name__:http_requests_total code:503] [{3 2000}]
+	// map[__name__:http_requests_total code:200] [{10 1000} {12 2000}]
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+)
+
+func ExampleScriptedResponder() {
+	lst := Listener{}
+	lst.Framer = LineFramer{}
+	lst.Responder = BannerResponder{
+		Banner: []byte("220 ready\n"),
+		Responder: &ScriptedResponder{
+			Replies: [][]byte{[]byte("250 first\n"), []byte("250 second\n")},
+		},
+	}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+
+	reader := bufio.NewReader(conn)
+	banner, err := reader.ReadString('\n')
+	if err != nil {
+		panic(err)
+	}
+	fmt.Print("Banner: ", banner)
+
+	for _, msg := range []string{"HELO", "MAIL"} {
+		_, err = fmt.Fprintln(conn, msg)
+		if err != nil {
+			panic(
+				fmt.Sprintf("while send data to socket: %v", err),
+			)
+		}
+		reply, err := reader.ReadString('\n')
+		if err != nil {
+			panic(err)
+		}
+		fmt.Print(msg, ": ", reply)
+	}
+
+	err = conn.Close()
+	if err != nil {
+		panic(
+			fmt.Sprintf("while close client: %v", err),
+		)
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	ci, _ := lst.GetConnection(1)
+	fmt.Println("Bytes in", ci.BytesIn, "bytes out", ci.BytesOut)
+
+	//Output:
+	// Banner: 220 ready
+	// HELO: 250 first
+	// MAIL: 250 second
+	// Bytes in 10 bytes out 31
+}
+
+func ExampleEchoResponder() {
+	lp := ListenerPacket{}
+	lp.Responder = EchoResponder{}
+	err := lp.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lp.GetAddress(), "udp://")
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+
+	_, err = conn.Write([]byte("ping"))
+	if err != nil {
+		panic(
+			fmt.Sprintf("while send data to socket: %v", err),
+		)
+	}
+
+	buffer := make([]byte, 16)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Reply:", string(buffer[0:n]))
+
+	err = conn.Close()
+	if err != nil {
+		panic(
+			fmt.Sprintf("while close client: %v", err),
+		)
+	}
+
+	err = lp.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	//Output:
+	// Reply: ping
+}
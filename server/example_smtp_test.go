@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+
+	"github.com/cyberluisda/saverserver-go/server/certs"
+)
+
+func ExampleSMTPListener() {
+	bundle, err := certs.NewBundle()
+	if err != nil {
+		panic(err)
+	}
+	lst := SMTPListener{
+		TLSListener: TLSListener{CertPem: bundle.Server.CertPEM, KeyPem: bundle.Server.KeyPEM},
+		SMTPConfig: SMTPConfig{
+			Hostname:     "mx.test",
+			Users:        map[string]string{"alerts": "secret"},
+			RequireAuth:  true,
+			RequireTLS:   true,
+			ParseMessage: true,
+			RejectRecipient: func(ci ConnectionInfo, addr string) bool {
+				return strings.HasSuffix(addr, "@unknown.test")
+			},
+		},
+	}
+	err = lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	client, err := smtp.Dial(strings.TrimPrefix(lst.GetAddress(), "tcp://"))
+	if err != nil {
+		panic(err)
+	}
+	err = client.Hello("alertmanager.test")
+	if err != nil {
+		panic(err)
+	}
+	// reply prints the SMTP error replies
+	reply := func(err error) string {
+		if tpErr, ok := err.(*textproto.Error); ok {
+			return fmt.Sprintf("%d %s", tpErr.Code, tpErr.Msg)
+		}
+		return fmt.Sprint(err)
+	}
+
+	fmt.Println("MAIL without TLS:", reply(client.Mail("alerts@example.test")))
+	err = client.StartTLS(bundle.ClientTLSConfig())
+	if err != nil {
+		panic(err)
+	}
+	err = client.Auth(smtp.PlainAuth("", "alerts", "secret", "localhost"))
+	if err != nil {
+		panic(err)
+	}
+	err = client.Mail("alerts@example.test")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("RCPT:", reply(client.Rcpt("oncall@example.test")))
+	fmt.Println("RCPT:", reply(client.Rcpt("nobody@unknown.test")))
+
+	w, err := client.Data()
+	if err != nil {
+		panic(err)
+	}
+	_, err = w.Write([]byte("From: Alerts <alerts@example.test>\r\n" +
+		"To: oncall@example.test\r\n" +
+		"Subject: Disk full\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/alternative; boundary=b1\r\n" +
+		"\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Disk usage is 99=25\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/html\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"PGI+RGlzayB1c2FnZSBpcyA5OSU8L2I+\r\n" +
+		"--b1--\r\n"))
+	if err != nil {
+		panic(err)
+	}
+	err = w.Close()
+	if err != nil {
+		panic(err)
+	}
+	err = client.Quit()
+	if err != nil {
+		panic(err)
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range lst.SMTPRecords() {
+		m := r.Message
+		fmt.Println(r.Seq, m.Helo, m.User, m.TLS, m.From, m.To, m.Header.Get("Subject"), m.Err)
+		for _, p := range m.Parts {
+			fmt.Printf("%s %q\n", p.Header.Get("Content-Type"), p.Body)
+		}
+	}
+
+	//Output:
+	// MAIL without TLS: 530 5.7.0 Must issue a STARTTLS command first
+	// RCPT: <nil>
+	// RCPT: 550 5.1.1 Mailbox unavailable
+	// 1 alertmanager.test alerts true alerts@example.test [oncall@example.test] Disk full <nil>
+	// text/plain; charset=utf-8 "Disk usage is 99%"
+	// text/html "<b>Disk usage is 99%</b>"
+}
+
+func ExampleSMTPListener_session() {
+	lst := SMTPListener{}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(lst.GetAddress(), "tcp://"))
+	if err != nil {
+		panic(err)
+	}
+	r := bufio.NewReader(conn)
+	send := func(line string) {
+		if line != "" {
+			_, err := conn.Write([]byte(line + "\r\n"))
+			if err != nil {
+				panic(err)
+			}
+		}
+		// Print the reply, all the lines of multiline replies
+		for {
+			reply, err := r.ReadString('\n')
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println(strings.TrimRight(reply, "\r\n"))
+			if len(reply) < 4 || reply[3] != '-' {
+				return
+			}
+		}
+	}
+
+	send("")
+	send("EHLO client.test")
+	send("AUTH LOGIN")
+	send("dXNlcg==")
+	send("cGFzcw==")
+	send("MAIL FROM:<> SIZE=100")
+	send("DATA")
+	send("RCPT TO:<postmaster@mx.test>")
+	send("DATA")
+	send("Subject: dots\r\n\r\n..starts with a dot\r\n.")
+	send("QUIT")
+
+	err = conn.Close()
+	if err != nil {
+		panic(err)
+	}
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range lst.SMTPRecords() {
+		fmt.Printf("%d %q %q %v %q\n", r.Seq, r.Message.User, r.Message.From, r.Message.To, r.Data)
+	}
+
+	//Output:
+	// 220 localhost ESMTP saverserver ready
+	// 250-localhost Hello client.test
+	// 250-SIZE 1048576
+	// 250-8BITMIME
+	// 250-PIPELINING
+	// 250 AUTH PLAIN LOGIN
+	// 334 VXNlcm5hbWU6
+	// 334 UGFzc3dvcmQ6
+	// 235 2.7.0 Authentication successful
+	// 250 2.1.0 Ok
+	// 503 5.5.1 Need RCPT command
+	// 250 2.1.5 Ok
+	// 354 End data with <CR><LF>.<CR><LF>
+	// 250 2.0.0 Ok: queued
+	// 221 2.0.0 Bye
+	// 1 "user" "" [postmaster@mx.test] "Subject: dots\r\n\r\n.starts with a dot\r\n"
+}
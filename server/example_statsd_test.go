@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+func ExampleStatsDListenerPacket() {
+	lst := StatsDListenerPacket{}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "udp://")
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+
+	datagrams := []string{
+		"requests:1|c|#env:prod,app:shop\nrequests:2|c|@0.5|#env:prod",
+		"requests:7|c|#env:dev",
+		"workers:10|g\nworkers:-3|g\nworkers:+1|g",
+		"latency:12|ms\nlatency:30:45|h|#env:prod",
+		"users:alice|s\nusers:bob|s\nusers:alice|s",
+		"_e{6,11}:Deploy|version 1.2|p:low|t:info|#env:prod",
+		"_sc|db.up|2|h:db1|m:connection refused",
+		"broken|x",
+	}
+	for _, d := range datagrams {
+		_, err = conn.Write([]byte(d))
+		if err != nil {
+			panic(
+				fmt.Sprintf("while send data to socket: %v", err),
+			)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = lst.WaitForRecords(ctx, 14)
+	if err != nil {
+		panic(err)
+	}
+
+	err = conn.Close()
+	if err != nil {
+		panic(
+			fmt.Sprintf("while close client: %v", err),
+		)
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("requests", lst.StatsD().Counter("requests"), lst.StatsD().Counter("requests", "env:prod"))
+	fmt.Println(lst.StatsD().Gauge("workers"))
+	fmt.Println("latency", lst.StatsD().Timings("latency"), lst.StatsD().Timings("latency", "env:prod"))
+	fmt.Println("users", lst.StatsD().Set("users"))
+	for _, r := range lst.StatsDRecords() {
+		m := r.Metric
+		switch {
+		case m.Err != nil:
+			fmt.Println(r.Seq, m.Err)
+		case m.Kind == StatsDEvent:
+			fmt.Println(r.Seq, m.Kind, m.Name, m.Text, m.Priority, m.AlertType, m.Tags)
+		case m.Kind == StatsDServiceCheck:
+			fmt.Println(r.Seq, m.Kind, m.Name, m.Status, m.Hostname, m.Text)
+		}
+	}
+
+	//Output:
+	// requests 12 5
+	// 8 true
+	// latency [12 30 45] [30 45]
+	// users [alice bob]
+	// 12 event Deploy version 1.2 low info [env:prod]
+	// 13 service check db.up 2 db1 connection refused
+	// 14 invalid statsd message: value not found in "broken|x"
+}
+
+func ExampleStatsDListener() {
+	lst := StatsDListener{}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+
+	_, err = conn.Write([]byte("jobs:1|c\njobs:1|c|@0.1\r\nqueue:5|g\n"))
+	if err != nil {
+		panic(
+			fmt.Sprintf("while send data to socket: %v", err),
+		)
+	}
+
+	err = conn.Close()
+	if err != nil {
+		panic(
+			fmt.Sprintf("while close client: %v", err),
+		)
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("jobs", lst.StatsD().Counter("jobs"))
+	fmt.Println(lst.StatsD().Gauge("queue"))
+
+	//Output:
+	// jobs 11
+	// 5 true
+}
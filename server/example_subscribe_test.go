@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+func ExamplePayloadStorage_Subscribe() {
+	lst := Listener{
+		ConnectionMgr: ConnectionMgr{
+			Framer: LineFramer{},
+		},
+	}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	errors, cancelSub := lst.Subscribe(ctx, SubscribeOptions{
+		Filter: func(r Record) bool { return bytes.HasPrefix(r.Data, []byte("ERROR")) },
+	})
+	defer cancelSub()
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+
+	_, err = conn.Write([]byte("INFO starting\nERROR disk full\nINFO retrying\nERROR disk still full\n"))
+	if err != nil {
+		panic(
+			fmt.Sprintf("while send data to socket: %v", err),
+		)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-errors:
+			fmt.Println(r.Seq, string(r.Data))
+		case <-ctx.Done():
+			fmt.Println("Timeout")
+		}
+	}
+
+	err = conn.Close()
+	if err != nil {
+		panic(
+			fmt.Sprintf("while close client: %v", err),
+		)
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	//Output:
+	// 2 ERROR disk full
+	// 4 ERROR disk still full
+}
+
+func ExampleOverflowPolicy() {
+	ps := PayloadStorage{}
+	ps.Init()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	newest, _ := ps.Subscribe(ctx, SubscribeOptions{BufferSize: 2, Overflow: OverflowDropNewest})
+	oldest, _ := ps.Subscribe(ctx, SubscribeOptions{BufferSize: 2, Overflow: OverflowDropOldest})
+
+	for i := 1; i <= 4; i++ {
+		msg := fmt.Sprintf("message %d", i)
+		ps.AddPayload("client", []byte(msg), len(msg))
+	}
+	fmt.Println("Subscribers", ps.Subscribers())
+
+	// Channels are closed when context is done
+	cancel()
+	for r := range newest {
+		fmt.Println("drop newest:", string(r.Data))
+	}
+	for r := range oldest {
+		fmt.Println("drop oldest:", string(r.Data))
+	}
+	fmt.Println("Subscribers", ps.Subscribers())
+
+	//Output:
+	// Subscribers 2
+	// drop newest: message 1
+	// drop newest: message 2
+	// drop oldest: message 3
+	// drop oldest: message 4
+	// Subscribers 0
+}
+
+func ExampleOverflowBlock() {
+	ps := PayloadStorage{}
+	ps.Init()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	records, _ := ps.Subscribe(ctx, SubscribeOptions{BufferSize: 1, Overflow: OverflowBlock})
+
+	saved := make(chan struct{})
+	go func() {
+		for i := 1; i <= 2; i++ {
+			msg := fmt.Sprintf("message %d", i)
+			ps.AddPayload("client", []byte(msg), len(msg))
+		}
+		close(saved)
+	}()
+
+	// Second message is saved but its sender is blocked until subscriber reads, storage is not blocked
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), time.Second)
+	defer waitCancel()
+	err := ps.WaitForRecords(waitCtx, 2)
+	fmt.Println("Saved", len(ps.Records()), err)
+	select {
+	case <-saved:
+		fmt.Println("Sender not blocked")
+	case <-time.After(100 * time.Millisecond):
+		fmt.Println("Sender blocked")
+	}
+
+	for i := 0; i < 2; i++ {
+		r := <-records
+		fmt.Println(string(r.Data))
+	}
+	<-saved
+	fmt.Println("Sender done")
+
+	//Output:
+	// Saved 2 <nil>
+	// Sender blocked
+	// message 1
+	// message 2
+	// Sender done
+}
+
+func ExampleOverflowBlock_stop() {
+	lst := Listener{
+		ConnectionMgr: ConnectionMgr{
+			Framer:      LineFramer{},
+			StopTimeout: time.Second,
+		},
+	}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	records, _ := lst.Subscribe(ctx, SubscribeOptions{BufferSize: 1, Overflow: OverflowBlock})
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+
+	_, err = conn.Write([]byte("first\nsecond\n"))
+	if err != nil {
+		panic(
+			fmt.Sprintf("while send data to socket: %v", err),
+		)
+	}
+	err = conn.Close()
+	if err != nil {
+		panic(
+			fmt.Sprintf("while close client: %v", err),
+		)
+	}
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), time.Second)
+	defer waitCancel()
+	err = lst.WaitForRecords(waitCtx, 2)
+	if err != nil {
+		panic(err)
+	}
+
+	// Connection is blocked by the subscriber that does not read, it is released by Stop
+	start := time.Now()
+	err = lst.Stop()
+	fmt.Println("Stop error:", err, "before timeout:", time.Since(start) < lst.StopTimeout)
+
+	// Pending records are delivered anyway
+	for i := 0; i < 2; i++ {
+		r := <-records
+		fmt.Println(string(r.Data))
+	}
+
+	//Output:
+	// Stop error: <nil> before timeout: true
+	// first
+	// second
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/cyberluisda/saverserver-go/server/syslog"
+)
+
+func ExamplePayloadStorage_SyslogRecords() {
+	lst := Listener{
+		ConnectionMgr: ConnectionMgr{
+			Framer: LineFramer{},
+		},
+	}
+	err := lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+
+	_, err = conn.Write([]byte(
+		"<14>Oct 11 22:14:15 host app: info message\n" +
+			"<11>Oct 11 22:14:16 host app: error message\n" +
+			"<11>1 2003-10-11T22:14:15.003Z host other 1234 - - error from other app\n" +
+			"not a syslog message\n",
+	))
+	if err != nil {
+		panic(
+			fmt.Sprintf("while send data to socket: %v", err),
+		)
+	}
+
+	err = conn.Close()
+	if err != nil {
+		panic(
+			fmt.Sprintf("while close client: %v", err),
+		)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = lst.WaitForRecords(ctx, 4) // Wait to ensure data was received
+	if err != nil {
+		panic(err)
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("#Syslog records", len(lst.SyslogRecords()))
+	for _, sr := range lst.SyslogRecordsByTag("app") {
+		fmt.Println("app:", sr.Seq, sr.Message.Message)
+	}
+	for _, sr := range lst.SyslogRecordsBySeverity(syslog.SeverityError) {
+		fmt.Println("error:", sr.Seq, sr.Message.AppName, sr.Message.Message)
+	}
+	for _, sr := range lst.SyslogParseErrors() {
+		fmt.Println("malformed:", sr.Seq, string(sr.Data), sr.Err)
+	}
+
+	//Output:
+	// #Syslog records 4
+	// app: 1 info message
+	// app: 2 error message
+	// error: 2 app error message
+	// error: 3 other error from other app
+	// malformed: 4 not a syslog message invalid syslog message: priority not found
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cyberluisda/saverserver-go/server/certs"
+)
+
+// dialTLS connects to the listener and writes the message. It returns the connection and the serial number of
+// the certificate served.
+func dialTLS(lst *TLSListener, bundle *certs.Bundle, msg string) (*tls.Conn, string) {
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: bundle.Root.CertPool(), ServerName: "localhost"})
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+	_, err = conn.Write([]byte(msg))
+	if err != nil {
+		panic(
+			fmt.Sprintf("while send data to socket: %v", err),
+		)
+	}
+	return conn, conn.ConnectionState().PeerCertificates[0].SerialNumber.Text(16)
+}
+
+func ExampleTLSListener_RotateCertificate() {
+	lst, bundle, err := NewEphemeralTLSListener()
+	if err != nil {
+		panic(err)
+	}
+	err = lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	first, firstSerial := dialTLS(lst, bundle, "before ")
+
+	next, err := bundle.Intermediate.NewServerCert()
+	if err != nil {
+		panic(err)
+	}
+	err = lst.RotateCertificate(next.CertPEM, next.KeyPEM)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Invalid rotation:", lst.RotateCertificate(next.CertPEM, bundle.Server.KeyPEM) != nil)
+
+	// Established connection continues with the previous certificate
+	_, err = first.Write([]byte("after"))
+	if err != nil {
+		panic(
+			fmt.Sprintf("while send data to socket: %v", err),
+		)
+	}
+	second, secondSerial := dialTLS(lst, bundle, "new")
+
+	for _, conn := range []*tls.Conn{first, second} {
+		err = conn.Close()
+		if err != nil {
+			panic(
+				fmt.Sprintf("while close client: %v", err),
+			)
+		}
+	}
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("First served with initial certificate:", firstSerial == bundle.Server.Certificate.SerialNumber.Text(16))
+	fmt.Println("Second served with new certificate:", secondSerial == next.Certificate.SerialNumber.Text(16))
+	for _, ci := range lst.GetConnections() {
+		fmt.Println(ci.ID, string(ci.Payload), ci.TLS.CertSerial == bundle.Server.Certificate.SerialNumber.Text(16))
+	}
+
+	//Output:
+	// Invalid rotation: true
+	// First served with initial certificate: true
+	// Second served with new certificate: true
+	// 1 before after true
+	// 2 new false
+}
+
+func ExampleTLSListener_certFiles() {
+	bundle, err := certs.NewBundle()
+	if err != nil {
+		panic(err)
+	}
+	dir, err := os.MkdirTemp("", "saverserver-certs")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles := func(kp *certs.KeyPair) {
+		err := os.WriteFile(filepath.Join(dir, "server.crt"), kp.CertPEM, 0600)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, "server.key"), kp.KeyPEM, 0600)
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+	writeFiles(bundle.Server)
+
+	lst := TLSListener{
+		CertFile:           filepath.Join(dir, "server.crt"),
+		KeyFile:            filepath.Join(dir, "server.key"),
+		CertReloadInterval: time.Millisecond * 10,
+	}
+	err = lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	conn, serial := dialTLS(&lst, bundle, "first")
+	_ = conn.Close()
+	fmt.Println("Served from files:", serial == bundle.Server.Certificate.SerialNumber.Text(16))
+
+	next, err := bundle.Intermediate.NewServerCert()
+	if err != nil {
+		panic(err)
+	}
+	writeFiles(next)
+
+	// Files are checked periodically
+	deadline := time.Now().Add(time.Second)
+	for serial != next.Certificate.SerialNumber.Text(16) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+		conn, serial = dialTLS(&lst, bundle, "retry")
+		_ = conn.Close()
+	}
+	fmt.Println("Served after files change:", serial == next.Certificate.SerialNumber.Text(16))
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	//Output:
+	// Served from files: true
+	// Served after files change: true
+}
+
+func ExampleTLSListener_serverNames() {
+	lst, bundle, err := NewEphemeralTLSListener()
+	if err != nil {
+		panic(err)
+	}
+	eu, err := bundle.Intermediate.NewServerCert("eu.collector.example")
+	if err != nil {
+		panic(err)
+	}
+	us, err := bundle.Intermediate.NewServerCert("*.us.collector.example")
+	if err != nil {
+		panic(err)
+	}
+	lst.ServerNames = map[string]TLSCertificate{
+		"eu.collector.example":   {CertPem: eu.CertPEM, KeyPem: eu.KeyPEM},
+		"*.us.collector.example": {CertPem: us.CertPEM, KeyPem: us.KeyPEM},
+	}
+	lst.UnknownServerNameAlert = TLSAlertAccessDenied
+	err = lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	for _, serverName := range []string{"EU.collector.example", "east.us.collector.example", "", "asia.collector.example"} {
+		rawConn, err := net.Dial("tcp", addr)
+		if err != nil {
+			panic(
+				fmt.Sprintf("while connect to address %s address: %v", addr, err),
+			)
+		}
+		// Server name is not sent if it is empty
+		conn := tls.Client(rawConn, &tls.Config{
+			RootCAs:            bundle.Root.CertPool(),
+			ServerName:         serverName,
+			InsecureSkipVerify: serverName == "", // nolint:gosec
+		})
+		err = conn.Handshake()
+		if err != nil {
+			_ = rawConn.Close()
+			fmt.Printf("%q: %v\n", serverName, err)
+			continue
+		}
+		fmt.Printf("%q: %v\n", serverName, conn.ConnectionState().PeerCertificates[0].DNSNames)
+		_ = conn.Close()
+	}
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, ci := range lst.GetConnections() {
+		fmt.Printf("%d %q %v\n", ci.ID, ci.TLS.ServerName, ci.Err)
+	}
+
+	//Output:
+	// "EU.collector.example": [eu.collector.example]
+	// "east.us.collector.example": [*.us.collector.example]
+	// "": [localhost]
+	// "asia.collector.example": remote error: tls: access denied
+	// 1 "EU.collector.example" <nil>
+	// 2 "east.us.collector.example" <nil>
+	// 3 "" <nil>
+	// 4 "asia.collector.example" unknown server name: asia.collector.example
+}
+
+func ExampleTLSInfo() {
+	lst, bundle, err := NewEphemeralTLSListener()
+	if err != nil {
+		panic(err)
+	}
+	lst.ClientAuth = tls.RequireAndVerifyClientCert
+	lst.NextProtos = []string{"syslog"}
+	err = lst.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	addr := strings.TrimPrefix(lst.GetAddress(), "tcp://")
+	cfg := bundle.ClientTLSConfig()
+	cfg.MinVersion = tls.VersionTLS13
+	cfg.NextProtos = []string{"h2", "syslog"}
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", addr, err),
+		)
+	}
+	_ = conn.Close()
+
+	// Client without certificate is rejected, and the failed handshake is recorded too
+	_, err = tls.Dial("tcp", addr, &tls.Config{RootCAs: bundle.Root.CertPool(), MaxVersion: tls.VersionTLS12})
+	fmt.Println("Client error:", err)
+
+	err = lst.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, ci := range lst.GetConnections() {
+		ti := ci.TLS
+		fmt.Println(ci.ID, ti.VersionName(), ti.CipherSuiteName() != "", ti.NegotiatedProtocol, ti.ServerName, ti.DidResume)
+		if ti.Err != nil {
+			fmt.Println("  Error:", ti.Err)
+			continue
+		}
+		peer := ti.PeerCertificate()
+		fmt.Println("  Peer:", peer.Subject.CommonName, bytes.Equal(peer.Raw, bundle.Client.Certificate.Raw))
+		for _, c := range ti.VerifiedChains[0] {
+			fmt.Println("  Chain:", c.Subject.CommonName)
+		}
+		fmt.Println("  Measured:", ti.HandshakeDuration > 0)
+	}
+
+	//Output:
+	// Client error: remote error: tls: handshake failure
+	// 1 TLS 1.3 true syslog localhost false
+	//   Peer: client true
+	//   Chain: client
+	//   Chain: saverserver Intermediate CA
+	//   Chain: saverserver Root CA
+	//   Measured: true
+	// 2 TLS 1.2 true  localhost false
+	//   Error: tls: client didn't provide a certificate
+}
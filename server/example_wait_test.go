@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+func ExamplePayloadStorage_WaitForBytes() {
+	lp := ListenerPacket{}
+	err := lp.Start()
+	if err != nil {
+		panic(err)
+	}
+
+	conn, err := net.Dial("udp", strings.TrimPrefix(lp.GetAddress(), "udp://"))
+	if err != nil {
+		panic(
+			fmt.Sprintf("while connect to address %s address: %v", lp.GetAddress(), err),
+		)
+	}
+
+	for _, msg := range []string{"hello", " world"} {
+		_, err = conn.Write([]byte(msg))
+		if err != nil {
+			panic(
+				fmt.Sprintf("while send data to socket: %v", err),
+			)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = lp.WaitForBytes(ctx, conn.LocalAddr().String(), 11)
+	fmt.Println("Wait error:", err)
+	fmt.Println(string(lp.GetPayload(conn.LocalAddr().String())))
+
+	ctxShort, cancelShort := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancelShort()
+	err = lp.WaitUntil(ctxShort, func(records []Record) bool {
+		for _, r := range records {
+			if string(r.Data) == "bye" {
+				return true
+			}
+		}
+		return false
+	})
+	fmt.Println("Wait error:", err)
+
+	err = conn.Close()
+	if err != nil {
+		panic(
+			fmt.Sprintf("while close client: %v", err),
+		)
+	}
+
+	err = lp.Stop()
+	if err != nil {
+		panic(err)
+	}
+
+	//Output:
+	// Wait error: <nil>
+	// hello world
+	// Wait error: while wait for condition over records: context deadline exceeded
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// FaultAction is a fault that can be injected in a connection.
+type FaultAction int
+
+const (
+	// FaultRefuse resets the connection just after it is accepted. See FaultPlan.RefuseFirst.
+	FaultRefuse FaultAction = iota + 1
+	// FaultDelay stops reading during FaultRule.Delay.
+	FaultDelay
+	// FaultThrottle limits the read bandwidth to FaultRule.BytesPerSecond.
+	FaultThrottle
+	// FaultClose closes the connection.
+	FaultClose
+	// FaultReset closes the connection sending a TCP RST (SO_LINGER 0).
+	FaultReset
+	// FaultHalfClose closes the write side of the connection. Data is still read.
+	FaultHalfClose
+	// FaultStall stops reading until the server is stopped.
+	FaultStall
+)
+
+// String returns a text representation of the action.
+func (fa FaultAction) String() string {
+	switch fa {
+	case FaultRefuse:
+		return "refuse"
+	case FaultDelay:
+		return "delay"
+	case FaultThrottle:
+		return "throttle"
+	case FaultClose:
+		return "close"
+	case FaultReset:
+		return "reset"
+	case FaultHalfClose:
+		return "half-close"
+	case FaultStall:
+		return "stall"
+	}
+	return "unknown"
+}
+
+// ErrFaultInjected is the error that closes the connections due to a fault injected.
+var ErrFaultInjected = errors.New("fault injected")
+
+// FaultRule is a fault injected in the connections that match the rule.
+type FaultRule struct {
+	// Action is the fault to inject.
+	Action FaultAction
+	// Connections is the list of connection numbers where the rule is applied. Connections are numbered in accept
+	// order starting from 1, see ConnectionInfo.ID. Rule is applied to all connections if it is empty.
+	Connections []uint64
+	// Probability is the probability of the rule to be applied to a connection. It is decided with the random
+	// generator initialized with FaultPlan.Seed and the connection ID, so it is deterministic. Value 0 is
+	// equivalent to 1, rule is always applied.
+	Probability float64
+	// AfterBytes is the number of bytes read in the connection before inject the fault. 0 means that fault is
+	// injected just after the connection is accepted.
+	AfterBytes int64
+	// EveryBytes repeats the FaultDelay fault each time this number of bytes are read after the first one.
+	EveryBytes int64
+	// Delay is the duration of the FaultDelay fault.
+	Delay time.Duration
+	// BytesPerSecond is the read bandwidth for FaultThrottle fault.
+	BytesPerSecond int64
+}
+
+// FaultPlan is the declarative list of faults injected in the connections of a stream listener.
+type FaultPlan struct {
+	// Seed is the seed of the random generator used to decide if rules with Probability are applied.
+	Seed int64
+	// RefuseFirst is the number of connections that are reset just after being accepted, starting from the first
+	// one.
+	RefuseFirst int
+	// Rules are the faults to inject. Rules are evaluated in order.
+	Rules []FaultRule
+}
+
+// FaultEvent is the record of a fault injected in a connection.
+type FaultEvent struct {
+	// Time is the moment when fault was injected.
+	Time time.Time
+	// Action is the fault injected.
+	Action FaultAction
+	// AtBytes is the number of bytes read in the connection when the fault was injected.
+	AtBytes int64
+}
+
+func (fp *FaultPlan) refuses(connID uint64) bool {
+	return connID <= uint64(fp.RefuseFirst)
+}
+
+// injector returns the fault injector for the connection or nil if no rule is applied to it.
+func (fp *FaultPlan) injector(scm *ConnectionMgr, ce *connEntry) *faultInjector {
+	rnd := rand.New(rand.NewSource(fp.Seed + int64(ce.info.ID))) // nolint:gosec
+
+	fi := &faultInjector{scm: scm, ce: ce}
+	for _, rule := range fp.Rules {
+		// Random value is generated always to keep the sequence deterministic
+		chance := rnd.Float64()
+		if !rule.appliesTo(ce.info.ID) || (rule.Probability > 0 && chance >= rule.Probability) {
+			continue
+		}
+		fi.rules = append(fi.rules, &plannedFault{FaultRule: rule, next: rule.AfterBytes})
+	}
+
+	if len(fi.rules) == 0 {
+		return nil
+	}
+	return fi
+}
+
+func (fr *FaultRule) appliesTo(connID uint64) bool {
+	if len(fr.Connections) == 0 {
+		return true
+	}
+	for _, id := range fr.Connections {
+		if id == connID {
+			return true
+		}
+	}
+	return false
+}
+
+// plannedFault is a rule applied to a connection.
+type plannedFault struct {
+	FaultRule
+	// next is the number of bytes read when next fault is injected
+	next int64
+	done bool
+}
+
+// faultInjector injects the faults in the reads of a connection.
+type faultInjector struct {
+	scm      *ConnectionMgr
+	ce       *connEntry
+	rules    []*plannedFault
+	bytes    int64
+	throttle int64
+}
+
+// throttleChunks is the number of reads per second when read bandwidth is throttled
+const throttleChunks = 10
+
+// read reads from conn injecting the faults planned. count is called with the number of bytes read.
+func (fi *faultInjector) read(conn net.Conn, p []byte, count func(int)) (int, error) {
+	limit := int64(len(p))
+	for _, pf := range fi.rules {
+		if pf.done {
+			continue
+		}
+		if fi.bytes >= pf.next {
+			err := fi.inject(pf)
+			if err != nil {
+				return 0, err
+			}
+		}
+		// Do not read beyond next fault
+		if !pf.done && pf.next-fi.bytes < limit {
+			limit = pf.next - fi.bytes
+		}
+	}
+	if fi.throttle > 0 && fi.throttle/throttleChunks < limit {
+		limit = fi.throttle / throttleChunks
+	}
+	if limit < 1 {
+		limit = 1
+	}
+
+	start := time.Now()
+	n, err := conn.Read(p[0:limit])
+	fi.bytes += int64(n)
+	count(n)
+
+	if fi.throttle > 0 && n > 0 {
+		expected := time.Duration(int64(n) * int64(time.Second) / fi.throttle)
+		fi.sleep(expected - time.Since(start))
+	}
+
+	return n, err
+}
+
+// inject applies the fault. It returns an error if connection must not be read anymore.
+func (fi *faultInjector) inject(pf *plannedFault) error {
+	fi.scm.recordFault(fi.ce, pf.Action)
+	pf.done = true
+
+	switch pf.Action {
+	case FaultDelay:
+		fi.sleep(pf.Delay)
+		if pf.EveryBytes > 0 {
+			pf.next += pf.EveryBytes
+			pf.done = false
+		}
+	case FaultThrottle:
+		fi.throttle = pf.BytesPerSecond
+	case FaultClose:
+		return fmt.Errorf("%w: %s after %d bytes", ErrFaultInjected, pf.Action, fi.bytes)
+	case FaultReset:
+		resetConn(fi.ce.raw)
+		return fmt.Errorf("%w: %s after %d bytes", ErrFaultInjected, pf.Action, fi.bytes)
+	case FaultHalfClose:
+		if cw, ok := fi.ce.raw.(interface{ CloseWrite() error }); ok {
+			err := cw.CloseWrite()
+			if err != nil {
+				log.Println("while close write side of connection:", err)
+			}
+		}
+	case FaultStall:
+		<-fi.scm.stopped
+		return fmt.Errorf("connection stalled until server stop: %w", net.ErrClosed)
+	}
+
+	return nil
+}
+
+// sleep waits for d or until the server is stopped.
+func (fi *faultInjector) sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-fi.scm.stopped:
+	}
+}
+
+// recordFault adds the fault to the connection log.
+func (scm *ConnectionMgr) recordFault(ce *connEntry, action FaultAction) {
+	scm.activeConnsMtx.Lock()
+	defer scm.activeConnsMtx.Unlock()
+	ce.info.Faults = append(ce.info.Faults, FaultEvent{
+		Time:    time.Now(),
+		Action:  action,
+		AtBytes: ce.info.BytesIn,
+	})
+}
+
+// resetConn closes the connection sending a TCP RST if it is supported.
+func resetConn(conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		err := tcpConn.SetLinger(0)
+		if err != nil {
+			log.Println("while set linger to reset connection:", err)
+		}
+	}
+
+	err := conn.Close()
+	if err != nil {
+		log.Println("while reset connection:", err)
+	}
+}
@@ -0,0 +1,461 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cyberluisda/saverserver-go/server/internal/msgpack"
+)
+
+// ForwardMode is the mode used to send the events in the Fluentd Forward protocol.
+type ForwardMode int
+
+const (
+	// ForwardMessage is the mode with one event per message: [tag, time, record, option].
+	ForwardMessage ForwardMode = iota + 1
+	// ForwardForward is the mode with an array of entries: [tag, [[time, record], ...], option].
+	ForwardForward
+	// ForwardPackedForward is the mode with the entries encoded in a binary: [tag, entries, option].
+	ForwardPackedForward
+	// ForwardCompressedPackedForward is ForwardPackedForward with the entries compressed with gzip.
+	ForwardCompressedPackedForward
+)
+
+// String returns a text representation of the mode.
+func (fm ForwardMode) String() string {
+	switch fm {
+	case ForwardMessage:
+		return "Message"
+	case ForwardForward:
+		return "Forward"
+	case ForwardPackedForward:
+		return "PackedForward"
+	case ForwardCompressedPackedForward:
+		return "CompressedPackedForward"
+	}
+	return "unknown"
+}
+
+// ForwardEntry is an event received by a Forward listener. It is saved as the Value of the records, and the record
+// of the event encoded in MessagePack is saved as the record Data.
+type ForwardEntry struct {
+	// Tag is the tag of the event.
+	Tag string
+	// Time is the time of the event.
+	Time time.Time
+	// Record is the event data decoded.
+	Record map[string]interface{}
+	// Mode is the mode of the message where event was received.
+	Mode ForwardMode
+	// Chunk is the chunk identifier of the message, if client requested an acknowledgement.
+	Chunk string
+}
+
+// ForwardConfig is the configuration of the Fluentd Forward protocol listeners.
+type ForwardConfig struct {
+	// SharedKey enables the handshake with HELO, PING and PONG messages to authenticate the clients with the key.
+	SharedKey string
+	// Users is the list of users and passwords accepted in the handshake. User authentication is required if it
+	// is not empty. It is only used if SharedKey is defined.
+	Users map[string]string
+	// SelfHostname is the hostname of the server in the handshake. "saverserver" is used if it is not defined.
+	SelfHostname string
+}
+
+// ForwardListener is a Listener that implements the server side of the Fluentd Forward protocol v1: messages in
+// all modes are decoded and each event is saved as a record, chunk acknowledgements are returned and the
+// optional handshake is done if SharedKey is defined. Only MessagePack encoding is supported. Framer and Responder
+// are not used.
+type ForwardListener struct {
+	Listener
+	ForwardConfig
+}
+
+// Start starts the server (listener) and enable the input data processing.
+func (fl *ForwardListener) Start() error {
+	fl.protocol = &fl.ForwardConfig
+	return fl.Listener.Start()
+}
+
+// StartContext starts the server like Start, and stops it when ctx is done.
+func (fl *ForwardListener) StartContext(ctx context.Context) error {
+	err := fl.Start()
+	if err != nil {
+		return err
+	}
+
+	stopWhenDone(ctx, fl.stopped, fl.Stop)
+	return nil
+}
+
+// ForwardTLSListener is like ForwardListener but over TLS. TLS settings are the same as TLSListener.
+type ForwardTLSListener struct {
+	TLSListener
+	ForwardConfig
+}
+
+// Start starts the server (listener) and enable the input data processing.
+func (ftl *ForwardTLSListener) Start() error {
+	ftl.protocol = &ftl.ForwardConfig
+	return ftl.TLSListener.Start()
+}
+
+// StartContext starts the server like Start, and stops it when ctx is done.
+func (ftl *ForwardTLSListener) StartContext(ctx context.Context) error {
+	err := ftl.Start()
+	if err != nil {
+		return err
+	}
+
+	stopWhenDone(ctx, ftl.stopped, ftl.Stop)
+	return nil
+}
+
+// ErrAuthentication is returned when a client can not be authenticated.
+var ErrAuthentication = errors.New("authentication failed")
+
+const defaultForwardHostname = "saverserver"
+
+// serveStream implements the streamProtocol interface.
+func (fc *ForwardConfig) serveStream(scm *ConnectionMgr, ps *PayloadStorage, ce *connEntry) error {
+	maxSize := scm.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	r := bufio.NewReader(&connReader{scm: scm, ce: ce})
+
+	if fc.SharedKey != "" {
+		err := fc.handshake(scm, ce, r, maxSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		msg, err := msgpack.ReadObject(r, maxSize)
+		if err != nil {
+			return err
+		}
+
+		entries, chunk, err := decodeForwardMessage(msg, maxSize)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			scm.saveValue(ps, ce, e.data, e.entry)
+		}
+
+		if chunk != "" {
+			err = fc.write(scm, ce, map[string]interface{}{"ack": chunk})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handshake sends HELO, checks the PING received and replies with PONG.
+func (fc *ForwardConfig) handshake(scm *ConnectionMgr, ce *connEntry, r *bufio.Reader, maxSize int) error {
+	nonce, err := randomBytes()
+	if err != nil {
+		return err
+	}
+	var authSalt interface{} = ""
+	if len(fc.Users) > 0 {
+		salt, err := randomBytes()
+		if err != nil {
+			return err
+		}
+		authSalt = salt
+	}
+
+	err = fc.write(scm, ce, []interface{}{"HELO", map[string]interface{}{
+		"nonce":     nonce,
+		"auth":      authSalt,
+		"keepalive": true,
+	}})
+	if err != nil {
+		return err
+	}
+
+	msg, err := msgpack.ReadObject(r, maxSize)
+	if err != nil {
+		return err
+	}
+	v, _, err := msgpack.Decode(msg)
+	if err != nil {
+		return err
+	}
+	ping, ok := v.([]interface{})
+	if !ok || len(ping) != 6 || forwardString(ping[0]) != "PING" {
+		return fmt.Errorf("%w: PING message expected in forward handshake", ErrInvalidFrame)
+	}
+
+	hostname := forwardString(ping[1])
+	sharedKeySalt := forwardString(ping[2])
+	digest := forwardString(ping[3])
+	username := forwardString(ping[4])
+	passwordDigest := forwardString(ping[5])
+
+	reason := ""
+	if digest != sha512Hex(sharedKeySalt, hostname, string(nonce), fc.SharedKey) {
+		reason = "shared_key mismatch"
+	} else if salt, ok := authSalt.([]byte); ok {
+		password, found := fc.Users[username]
+		if !found || passwordDigest != sha512Hex(string(salt), username, password) {
+			reason = "username/password mismatch"
+		}
+	}
+
+	selfHostname := fc.SelfHostname
+	if selfHostname == "" {
+		selfHostname = defaultForwardHostname
+	}
+	err = fc.write(scm, ce, []interface{}{
+		"PONG",
+		reason == "",
+		reason,
+		selfHostname,
+		sha512Hex(sharedKeySalt, selfHostname, string(nonce), fc.SharedKey),
+	})
+	if err != nil {
+		return err
+	}
+
+	if reason != "" {
+		return fmt.Errorf("%w: %s from %s", ErrAuthentication, reason, hostname)
+	}
+	return nil
+}
+
+func (fc *ForwardConfig) write(scm *ConnectionMgr, ce *connEntry, v interface{}) error {
+	data, err := msgpack.Append(nil, v)
+	if err != nil {
+		return fmt.Errorf("while encode forward response: %w", err)
+	}
+	return scm.writeResponse(ce, data)
+}
+
+// forwardEntry is an entry decoded and its record encoded.
+type forwardEntry struct {
+	entry *ForwardEntry
+	data  []byte
+}
+
+// decodeForwardMessage returns the entries of a message and the chunk identifier if it is defined in the options.
+// maxSize is the maximum size of the compressed entries once they are decompressed.
+func decodeForwardMessage(msg []byte, maxSize int) ([]forwardEntry, string, error) {
+	elems, _, err := msgpack.SplitArray(msg)
+	if err != nil || len(elems) < 2 || len(elems) > 4 {
+		return nil, "", fmt.Errorf("%w: invalid forward message", ErrInvalidFrame)
+	}
+
+	tag, _, err := msgpack.Decode(elems[0])
+	if _, ok := tag.(string); err != nil || !ok {
+		return nil, "", fmt.Errorf("%w: invalid tag in forward message", ErrInvalidFrame)
+	}
+
+	second, _, err := msgpack.Decode(elems[1])
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+	}
+
+	mode := ForwardMessage
+	var entries [][]byte
+	optionIdx := 2
+	switch second.(type) {
+	case []interface{}:
+		mode = ForwardForward
+		entries, _, err = msgpack.SplitArray(elems[1])
+	case string, []byte:
+		mode = ForwardPackedForward
+	default:
+		// Message mode: time and record are elements of the message
+		optionIdx = 3
+		if len(elems) < 3 {
+			return nil, "", fmt.Errorf("%w: record not found in forward message", ErrInvalidFrame)
+		}
+		entries = [][]byte{append(append([]byte{0x92}, elems[1]...), elems[2]...)}
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+	}
+
+	option := map[string]interface{}{}
+	if len(elems) > optionIdx {
+		v, _, err := msgpack.Decode(elems[optionIdx])
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			option = m
+		}
+	}
+
+	if mode == ForwardPackedForward {
+		packed := []byte(forwardString(second))
+		if forwardString(option["compressed"]) == "gzip" {
+			mode = ForwardCompressedPackedForward
+			packed, err = gunzip(packed, maxSize)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		entries, err = splitPackedEntries(packed)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	chunk := forwardString(option["chunk"])
+	r := make([]forwardEntry, 0, len(entries))
+	for _, e := range entries {
+		fe, err := decodeForwardEntry(e)
+		if err != nil {
+			return nil, "", err
+		}
+		fe.entry.Tag = tag.(string)
+		fe.entry.Mode = mode
+		fe.entry.Chunk = chunk
+		r = append(r, fe)
+	}
+	return r, chunk, nil
+}
+
+// decodeForwardEntry decodes an entry [time, record].
+func decodeForwardEntry(data []byte) (forwardEntry, error) {
+	elems, _, err := msgpack.SplitArray(data)
+	if err != nil || len(elems) != 2 {
+		return forwardEntry{}, fmt.Errorf("%w: invalid forward entry", ErrInvalidFrame)
+	}
+
+	t, _, err := msgpack.Decode(elems[0])
+	if err != nil {
+		return forwardEntry{}, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+	}
+	eventTime, err := forwardTime(t)
+	if err != nil {
+		return forwardEntry{}, err
+	}
+
+	record, _, err := msgpack.Decode(elems[1])
+	if err != nil {
+		return forwardEntry{}, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+	}
+	recordMap, ok := record.(map[string]interface{})
+	if !ok {
+		return forwardEntry{}, fmt.Errorf("%w: record of forward entry is not a map", ErrInvalidFrame)
+	}
+
+	return forwardEntry{
+		entry: &ForwardEntry{Time: eventTime, Record: recordMap},
+		data:  elems[1],
+	}, nil
+}
+
+// splitPackedEntries returns the entries encoded one after another in packed.
+func splitPackedEntries(packed []byte) ([][]byte, error) {
+	var entries [][]byte
+	for len(packed) > 0 {
+		_, n, err := msgpack.Decode(packed)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFrame, err)
+		}
+		entries = append(entries, packed[0:n])
+		packed = packed[n:]
+	}
+	return entries, nil
+}
+
+// forwardTime converts the time of an entry: an integer with seconds or the EventTime extension.
+func forwardTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case int64:
+		return time.Unix(t, 0), nil
+	case uint64:
+		return time.Unix(int64(t), 0), nil
+	case float64:
+		return time.Unix(0, int64(t*float64(time.Second))), nil
+	case msgpack.Ext:
+		if t.Type == 0 && len(t.Data) == 8 {
+			sec := binary.BigEndian.Uint32(t.Data[0:4])
+			nsec := binary.BigEndian.Uint32(t.Data[4:8])
+			return time.Unix(int64(sec), int64(nsec)), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: invalid time in forward entry", ErrInvalidFrame)
+}
+
+// gunzip decompresses data. It returns ErrInvalidFrame if the result is bigger than maxSize bytes.
+func gunzip(data []byte, maxSize int) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("while decompress forward entries: %w", err)
+	}
+	defer gz.Close()
+
+	r, err := io.ReadAll(io.LimitReader(gz, int64(maxSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("while decompress forward entries: %w", err)
+	}
+	if len(r) > maxSize {
+		return nil, fmt.Errorf("%w: decompressed forward entries are bigger than %d bytes", ErrInvalidFrame, maxSize)
+	}
+	return r, nil
+}
+
+// forwardString returns v if it is a string or a binary, or an empty string.
+func forwardString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	return ""
+}
+
+func randomBytes() ([]byte, error) {
+	r := make([]byte, 16)
+	_, err := rand.Read(r)
+	if err != nil {
+		return nil, fmt.Errorf("while generate random bytes: %w", err)
+	}
+	return r, nil
+}
+
+func sha512Hex(values ...string) string {
+	h := sha512.New()
+	for _, v := range values {
+		h.Write([]byte(v))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ForwardRecord is a record saved by a Forward listener.
+type ForwardRecord struct {
+	Record
+	// Entry is the event received.
+	Entry *ForwardEntry
+}
+
+// ForwardRecords returns the events received by Forward listeners in arrival order.
+func (ps *PayloadStorage) ForwardRecords() []ForwardRecord {
+	var r []ForwardRecord
+	for _, rec := range ps.Records() {
+		if entry, ok := rec.Value.(*ForwardEntry); ok {
+			r = append(r, ForwardRecord{Record: rec, Entry: entry})
+		}
+	}
+	return r
+}
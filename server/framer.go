@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Framer splits the stream of bytes received in a connection in messages. When a Framer is defined in a
+// ConnectionMgr, each message is passed to CallBack and saved as a record instead of the raw data read from the
+// connection.
+type Framer interface {
+	// Split has the same contract as bufio.SplitFunc: it returns the number of bytes to advance the input and the
+	// next message if data contains a full one.
+	Split(data []byte, atEOF bool) (advance int, token []byte, err error)
+}
+
+// FramerFunc is an adapter to use ordinary functions as Framer.
+type FramerFunc func(data []byte, atEOF bool) (advance int, token []byte, err error)
+
+// Split calls f(data, atEOF).
+func (f FramerFunc) Split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	return f(data, atEOF)
+}
+
+// DefaultMaxMessageSize is the default max size of a message when a Framer is used
+const DefaultMaxMessageSize = 1024 * 1024
+
+// ErrInvalidFrame is returned by framers when data received does not follow the expected format.
+var ErrInvalidFrame = errors.New("invalid frame")
+
+// ErrTruncatedFrame is returned by framers when connection is closed in the middle of a message.
+var ErrTruncatedFrame = errors.New("truncated frame")
+
+// LineFramer splits messages delimited by LF or CRLF. The delimiter is not included in the messages. Last
+// message is returned on connection close even if it is not terminated.
+type LineFramer struct{}
+
+// Split implements the Framer interface.
+func (LineFramer) Split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, bytes.TrimSuffix(data[0:i], []byte{'\r'}), nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), bytes.TrimSuffix(data, []byte{'\r'}), nil
+	}
+
+	return 0, nil, nil
+}
+
+// DelimiterFramer splits messages terminated by Delimiter. The delimiter is not included in the messages. Last
+// message is returned on connection close even if it is not terminated.
+type DelimiterFramer struct {
+	Delimiter []byte
+}
+
+// Split implements the Framer interface.
+func (df DelimiterFramer) Split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(df.Delimiter) == 0 {
+		return 0, nil, fmt.Errorf("%w: empty delimiter", ErrInvalidFrame)
+	}
+	if i := bytes.Index(data, df.Delimiter); i >= 0 {
+		return i + len(df.Delimiter), data[0:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
+
+// maxOctetCountingDigits is the max number of digits accepted in the length of a octet-counting frame
+const maxOctetCountingDigits = 10
+
+// OctetCountingFramer splits messages using the octet-counting method defined in RFC 6587: "MSG-LEN SP MSG".
+type OctetCountingFramer struct{}
+
+// Split implements the Framer interface.
+func (OctetCountingFramer) Split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	msgLen := 0
+	i := 0
+	for ; i < len(data) && data[i] != ' '; i++ {
+		c := data[i]
+		if c < '0' || c > '9' || i >= maxOctetCountingDigits || (i == 0 && c == '0') {
+			return 0, nil, fmt.Errorf("%w: unexpected character %q in octet-counting length", ErrInvalidFrame, c)
+		}
+		msgLen = msgLen*10 + int(c-'0')
+	}
+
+	switch {
+	case i == len(data):
+		// length is not complete
+	case i == 0:
+		return 0, nil, fmt.Errorf("%w: octet-counting length is empty", ErrInvalidFrame)
+	case len(data) >= i+1+msgLen:
+		return i + 1 + msgLen, data[i+1 : i+1+msgLen], nil
+	}
+
+	if atEOF {
+		return 0, nil, ErrTruncatedFrame
+	}
+
+	return 0, nil, nil
+}
+
+// LengthPrefixFramer splits messages prefixed with its length encoded as a big-endian unsigned integer of Size
+// bytes. Valid sizes are 2 and 4. The prefix is not included in the messages.
+type LengthPrefixFramer struct {
+	Size int
+}
+
+// Split implements the Framer interface.
+func (lpf LengthPrefixFramer) Split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if lpf.Size != 2 && lpf.Size != 4 {
+		return 0, nil, fmt.Errorf("%w: unsupported length prefix size %d", ErrInvalidFrame, lpf.Size)
+	}
+
+	if len(data) >= lpf.Size {
+		var msgLen int
+		if lpf.Size == 2 {
+			msgLen = int(binary.BigEndian.Uint16(data))
+		} else {
+			msgLen = int(binary.BigEndian.Uint32(data))
+		}
+
+		if len(data) >= lpf.Size+msgLen {
+			return lpf.Size + msgLen, data[lpf.Size : lpf.Size+msgLen], nil
+		}
+	}
+
+	if atEOF && len(data) > 0 {
+		return 0, nil, ErrTruncatedFrame
+	}
+
+	return 0, nil, nil
+}
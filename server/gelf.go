@@ -0,0 +1,391 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultGELFChunkTimeout is the max time to receive all chunks of a GELF message if it is not defined.
+const DefaultGELFChunkTimeout = time.Second * 5
+
+// gelfMaxChunks is the max number of chunks of a GELF message.
+const gelfMaxChunks = 128
+
+// gelfChunkMagic are the first bytes of a GELF chunk.
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+// GELFMessage is a message received by a GELF listener. It is saved as the Value of the records, and the GELF
+// document, decompressed and reassembled, is saved as the record Data.
+type GELFMessage struct {
+	// Version is the GELF spec version.
+	Version string
+	// Host is the name of the host that sent the message.
+	Host string
+	// ShortMessage is the short message.
+	ShortMessage string
+	// FullMessage is the long message.
+	FullMessage string
+	// Timestamp is the time of the message in seconds since epoch, with optional decimals.
+	Timestamp float64
+	// Level is the syslog level of the message. See syslog.SeverityEmergency and related constants. It is 1
+	// (syslog.SeverityAlert) if the message does not define it.
+	Level int
+	// Fields are the additional fields, with the underscore prefix, and the deprecated ones like facility.
+	Fields map[string]interface{}
+	// Chunks is the number of chunks of the message. It is 0 if it was not chunked.
+	Chunks int
+	// MessageID is the message identifier, in hex format, of chunked messages.
+	MessageID string
+	// Expired is true if not all chunks of the message were received before the timeout. In this case, Data of
+	// the record is the concatenation of the chunks received and ChunksReceived is the number of them.
+	Expired bool
+	// ChunksReceived is the number of chunks received of an expired message.
+	ChunksReceived int
+	// Err is the error found while decompress or parse the message, if any.
+	Err error
+}
+
+// GELFConfig is the configuration of the GELF listeners.
+type GELFConfig struct {
+	// ChunkTimeout is the max time to receive all the chunks of a message in packet listeners. After it, the
+	// message is saved as expired. DefaultGELFChunkTimeout is used if it is not defined.
+	ChunkTimeout time.Duration
+	// MaxDecompressedSize is the max size of a message after it is decompressed. Bigger messages are saved
+	// compressed with an error. DefaultMaxMessageSize is used if it is not defined.
+	MaxDecompressedSize int
+}
+
+// maxDecompressedSize returns MaxDecompressedSize or its default value.
+func (gc *GELFConfig) maxDecompressedSize() int {
+	if gc.MaxDecompressedSize <= 0 {
+		return DefaultMaxMessageSize
+	}
+	return gc.MaxDecompressedSize
+}
+
+// GELFListenerPacket is a ListenerPacket that decodes GELF messages: chunked messages are reassembled, gzip and
+// zlib payloads are decompressed and the JSON document is parsed. Each message is saved as a record. The chunk
+// sets that are incomplete when ChunkTimeout expires, or when the server is stopped, are saved as expired
+// messages. Responder is not used.
+type GELFListenerPacket struct {
+	ListenerPacket
+	GELFConfig
+
+	chunksMtx sync.Mutex
+	chunks    map[string]*gelfChunkSet
+}
+
+// gelfChunkSet are the chunks received of a message.
+type gelfChunkSet struct {
+	addr     string
+	first    time.Time
+	chunks   [][]byte
+	received int
+}
+
+// Start starts the server (listener) and enable the input data processing.
+func (gl *GELFListenerPacket) Start() error {
+	if gl.ChunkTimeout <= 0 {
+		gl.ChunkTimeout = DefaultGELFChunkTimeout
+	}
+	gl.protocol = gl
+
+	err := gl.ListenerPacket.Start()
+	if err != nil {
+		return err
+	}
+
+	go gl.expireChunks(gl.stopped)
+	return nil
+}
+
+// StartContext starts the server like Start, and stops it when ctx is done.
+func (gl *GELFListenerPacket) StartContext(ctx context.Context) error {
+	err := gl.Start()
+	if err != nil {
+		return err
+	}
+
+	stopWhenDone(ctx, gl.stopped, gl.Stop)
+	return nil
+}
+
+// Stop stops the listener, no more connections will be allowed and data processing is stopped.
+func (gl *GELFListenerPacket) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultSopTimeout)
+	defer cancel()
+	return gl.Shutdown(ctx)
+}
+
+// Shutdown stops the server like ListenerPacket.Shutdown, and then saves the incomplete chunk sets as expired
+// messages.
+func (gl *GELFListenerPacket) Shutdown(ctx context.Context) error {
+	err := gl.ListenerPacket.Shutdown(ctx)
+	gl.saveExpired(time.Time{})
+	return err
+}
+
+// handlePacket implements the packetProtocol interface.
+func (gl *GELFListenerPacket) handlePacket(_ *ListenerPacket, addr net.Addr, data []byte) {
+	if !bytes.HasPrefix(data, gelfChunkMagic) {
+		gl.save(addr.String(), data, &GELFMessage{})
+		return
+	}
+
+	// Chunk header: magic (2 bytes), message id (8 bytes), sequence number (1 byte) and sequence count (1 byte)
+	if len(data) < 12 {
+		gl.save(addr.String(), data, &GELFMessage{Err: fmt.Errorf("%w: GELF chunk header is too short", ErrInvalidFrame)})
+		return
+	}
+	id := hex.EncodeToString(data[2:10])
+	seq, count := int(data[10]), int(data[11])
+	if count == 0 || count > gelfMaxChunks || seq >= count {
+		gl.save(addr.String(), data, &GELFMessage{
+			MessageID: id,
+			Err:       fmt.Errorf("%w: GELF chunk %d of %d", ErrInvalidFrame, seq, count),
+		})
+		return
+	}
+
+	gl.chunksMtx.Lock()
+	if gl.chunks == nil {
+		gl.chunks = make(map[string]*gelfChunkSet)
+	}
+	set, found := gl.chunks[id]
+	if !found {
+		set = &gelfChunkSet{addr: addr.String(), first: time.Now(), chunks: make([][]byte, count)}
+		gl.chunks[id] = set
+	}
+	if seq < len(set.chunks) && set.chunks[seq] == nil {
+		set.chunks[seq] = append([]byte(nil), data[12:]...)
+		set.received++
+	}
+	complete := set.received == len(set.chunks)
+	if complete {
+		delete(gl.chunks, id)
+	}
+	gl.chunksMtx.Unlock()
+
+	if complete {
+		gl.save(set.addr, bytes.Join(set.chunks, nil), &GELFMessage{Chunks: len(set.chunks), MessageID: id})
+	}
+}
+
+// save decodes data in msg and saves it as a record.
+func (gl *GELFListenerPacket) save(addr string, data []byte, msg *GELFMessage) {
+	if msg.Err == nil {
+		data, msg.Err = decodeGELF(data, gl.maxDecompressedSize(), msg)
+	}
+	gl.addRecord(addr, 0, data, msg)
+}
+
+// expireChunks saves the expired chunk sets periodically until stopped is closed.
+func (gl *GELFListenerPacket) expireChunks(stopped <-chan struct{}) {
+	interval := gl.ChunkTimeout / 4
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			gl.saveExpired(time.Now().Add(-gl.ChunkTimeout))
+		case <-stopped:
+			return
+		}
+	}
+}
+
+// saveExpired saves the chunk sets which first chunk was received before limit as expired messages. All of them
+// are saved if limit is zero.
+func (gl *GELFListenerPacket) saveExpired(limit time.Time) {
+	gl.chunksMtx.Lock()
+	var ids []string
+	for id, set := range gl.chunks {
+		if limit.IsZero() || set.first.Before(limit) {
+			ids = append(ids, id)
+		}
+	}
+	// Expired messages are saved in arrival order of their first chunk
+	sort.Slice(ids, func(i, j int) bool { return gl.chunks[ids[i]].first.Before(gl.chunks[ids[j]].first) })
+	sets := make([]*gelfChunkSet, len(ids))
+	for i, id := range ids {
+		sets[i] = gl.chunks[id]
+		delete(gl.chunks, id)
+	}
+	gl.chunksMtx.Unlock()
+
+	for i, set := range sets {
+		gl.addRecord(set.addr, 0, bytes.Join(set.chunks, nil), &GELFMessage{
+			Chunks:         len(set.chunks),
+			MessageID:      ids[i],
+			Expired:        true,
+			ChunksReceived: set.received,
+		})
+	}
+}
+
+// GELFListener is a Listener that decodes GELF messages delimited by null bytes. Each message is saved as a
+// record. Framer and Responder are not used.
+type GELFListener struct {
+	Listener
+	GELFConfig
+}
+
+// Start starts the server (listener) and enable the input data processing.
+func (gl *GELFListener) Start() error {
+	gl.protocol = &gl.GELFConfig
+	return gl.Listener.Start()
+}
+
+// StartContext starts the server like Start, and stops it when ctx is done.
+func (gl *GELFListener) StartContext(ctx context.Context) error {
+	err := gl.Start()
+	if err != nil {
+		return err
+	}
+
+	stopWhenDone(ctx, gl.stopped, gl.Stop)
+	return nil
+}
+
+// GELFTLSListener is like GELFListener but over TLS. TLS settings are the same as TLSListener.
+type GELFTLSListener struct {
+	TLSListener
+	GELFConfig
+}
+
+// Start starts the server (listener) and enable the input data processing.
+func (gtl *GELFTLSListener) Start() error {
+	gtl.protocol = &gtl.GELFConfig
+	return gtl.TLSListener.Start()
+}
+
+// StartContext starts the server like Start, and stops it when ctx is done.
+func (gtl *GELFTLSListener) StartContext(ctx context.Context) error {
+	err := gtl.Start()
+	if err != nil {
+		return err
+	}
+
+	stopWhenDone(ctx, gtl.stopped, gtl.Stop)
+	return nil
+}
+
+// serveStream implements the streamProtocol interface.
+func (gc *GELFConfig) serveStream(scm *ConnectionMgr, ps *PayloadStorage, ce *connEntry) error {
+	maxSize := scm.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+
+	scanner := bufio.NewScanner(&connReader{scm: scm, ce: ce})
+	scanner.Buffer(make([]byte, readBufferSize), maxSize)
+	scanner.Split(DelimiterFramer{Delimiter: []byte{0}}.Split)
+	for scanner.Scan() {
+		data := scanner.Bytes()
+		if len(data) == 0 {
+			continue
+		}
+
+		msg := &GELFMessage{}
+		data, msg.Err = decodeGELF(data, gc.maxDecompressedSize(), msg)
+		scm.saveValue(ps, ce, data, msg)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// decodeGELF decompresses data if it is needed, up to maxSize bytes, and parses the document in msg. It returns
+// the document.
+func decodeGELF(data []byte, maxSize int, msg *GELFMessage) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch {
+	case len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case len(data) >= 2 && data[0]&0x0f == 8 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0:
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	}
+	if err != nil {
+		return data, fmt.Errorf("while decompress GELF message: %w", err)
+	}
+	if r != nil {
+		defer r.Close()
+		decompressed, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+		if err != nil {
+			return data, fmt.Errorf("while decompress GELF message: %w", err)
+		}
+		if len(decompressed) > maxSize {
+			return data, fmt.Errorf("%w: decompressed GELF message is bigger than %d bytes", ErrInvalidFrame, maxSize)
+		}
+		data = decompressed
+	}
+
+	var doc map[string]interface{}
+	err = json.Unmarshal(data, &doc)
+	if err != nil {
+		return data, fmt.Errorf("%w: invalid GELF JSON: %v", ErrInvalidFrame, err)
+	}
+
+	// Level is 1 (alert) when it is not defined, like in Graylog
+	msg.Level = 1
+	msg.Fields = map[string]interface{}{}
+	for k, v := range doc {
+		switch k {
+		case "version":
+			msg.Version, _ = v.(string)
+		case "host":
+			msg.Host, _ = v.(string)
+		case "short_message":
+			msg.ShortMessage, _ = v.(string)
+		case "full_message":
+			msg.FullMessage, _ = v.(string)
+		case "timestamp":
+			msg.Timestamp, _ = v.(float64)
+		case "level":
+			level, _ := v.(float64)
+			msg.Level = int(level)
+		default:
+			msg.Fields[k] = v
+		}
+	}
+
+	if msg.Host == "" || msg.ShortMessage == "" {
+		return data, fmt.Errorf("%w: GELF message without host or short_message", ErrInvalidFrame)
+	}
+	return data, nil
+}
+
+// GELFRecord is a record saved by a GELF listener.
+type GELFRecord struct {
+	Record
+	// Message is the message received.
+	Message *GELFMessage
+}
+
+// GELFRecords returns the messages received by GELF listeners in arrival order, including the expired ones.
+func (ps *PayloadStorage) GELFRecords() []GELFRecord {
+	var r []GELFRecord
+	for _, rec := range ps.Records() {
+		if msg, ok := rec.Value.(*GELFMessage); ok {
+			r = append(r, GELFRecord{Record: rec, Message: msg})
+		}
+	}
+	return r
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// HECEventPath is the path of the Splunk HTTP Event Collector endpoint for JSON events.
+	HECEventPath = "/services/collector/event"
+	// HECAckPath is the path of the Splunk HTTP Event Collector endpoint to query indexer acknowledgements.
+	HECAckPath = "/services/collector/ack"
+)
+
+// HECEvent is an event received by a HECEndpoint. It is saved as the Value of the records, and the JSON object of
+// the event is saved as the record Data.
+type HECEvent struct {
+	// Time is the event time in epoch format with optional decimals.
+	Time json.Number `json:"time,omitempty"`
+	// Host is the host value of the event.
+	Host string `json:"host,omitempty"`
+	// Source is the source value of the event.
+	Source string `json:"source,omitempty"`
+	// SourceType is the sourcetype value of the event.
+	SourceType string `json:"sourcetype,omitempty"`
+	// Index is the index where the event must be saved.
+	Index string `json:"index,omitempty"`
+	// Event is the event data. It is a string or a JSON object.
+	Event interface{} `json:"event"`
+	// Fields are the indexed fields of the event.
+	Fields map[string]interface{} `json:"fields,omitempty"`
+	// Channel is the data channel of the request, if it was sent.
+	Channel string `json:"-"`
+	// AckID is the acknowledgement identifier of the request when acknowledgements are enabled.
+	AckID uint64 `json:"-"`
+}
+
+// HECEndpoint emulates the Splunk HTTP Event Collector (HEC) JSON events API and the indexer acknowledgement API.
+// See HECEventPath and HECAckPath.
+type HECEndpoint struct {
+	// Tokens is the list of tokens accepted in the Authorization header, with format "Splunk <token>". Any token
+	// is accepted if it is empty, but the header is required.
+	Tokens []string
+	// Ack enables the indexer acknowledgement: requests must include the channel in the X-Splunk-Request-Channel
+	// header or in the channel query parameter, and an ackId is returned for each request.
+	Ack bool
+	// Reject returns true if the event number n (starting from 0) in the request must be rejected. Then the whole
+	// request is discarded without an ackId and the server busy error is returned. No event is rejected if it is nil.
+	Reject func(n int, event *HECEvent) bool
+
+	mtx  sync.Mutex
+	acks map[string]uint64
+}
+
+// Routes returns the routes to use the endpoint in an HTTPListener.
+func (he *HECEndpoint) Routes() []HTTPRoute {
+	return []HTTPRoute{
+		{Method: http.MethodPost, Path: HECEventPath, Endpoint: he},
+		{Method: http.MethodPost, Path: HECAckPath, Endpoint: he},
+	}
+}
+
+// hecStatus is the body of the HEC responses.
+type hecStatus struct {
+	Text               string  `json:"text"`
+	Code               int     `json:"code"`
+	InvalidEventNumber *int    `json:"invalid-event-number,omitempty"`
+	AckID              *uint64 `json:"ackId,omitempty"`
+}
+
+// Handle implements the HTTPEndpoint interface.
+func (he *HECEndpoint) Handle(req *HTTPRequest, save func(data []byte, value interface{})) HTTPResponse {
+	if resp, ok := he.authorize(req); !ok {
+		return resp
+	}
+
+	channel := req.Header.Get("X-Splunk-Request-Channel")
+	if channel == "" {
+		channel = req.Query.Get("channel")
+	}
+	if he.Ack && channel == "" {
+		return hecResponse(http.StatusBadRequest, hecStatus{Text: "Data channel is missing", Code: 10})
+	}
+
+	if strings.HasSuffix(req.Path, "/ack") {
+		return he.handleAck(channel, req.Body)
+	}
+
+	var events []*HECEvent
+	var raws []json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(req.Body))
+	dec.UseNumber()
+	for n := 0; ; n++ {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		event := &HECEvent{}
+		if err == nil {
+			err = json.Unmarshal(raw, event)
+		}
+		if err != nil {
+			return hecResponse(http.StatusBadRequest, hecStatus{Text: "Invalid data format", Code: 6, InvalidEventNumber: &n})
+		}
+		if event.Event == nil || event.Event == "" {
+			return hecResponse(http.StatusBadRequest, hecStatus{
+				Text: "Event field cannot be blank", Code: 13, InvalidEventNumber: &n,
+			})
+		}
+
+		event.Channel = channel
+		events = append(events, event)
+		raws = append(raws, raw)
+	}
+	if len(events) == 0 {
+		return hecResponse(http.StatusBadRequest, hecStatus{Text: "No data", Code: 5})
+	}
+
+	if he.Reject != nil {
+		for n, event := range events {
+			if he.Reject(n, event) {
+				return hecResponse(http.StatusServiceUnavailable, hecStatus{
+					Text: "Server is busy", Code: 9, InvalidEventNumber: &n,
+				})
+			}
+		}
+	}
+
+	// Ack identifier is issued only for accepted requests
+	var ackID uint64
+	if he.Ack {
+		ackID = he.nextAckID(channel)
+	}
+	for n, event := range events {
+		event.AckID = ackID
+		save(raws[n], event)
+	}
+
+	status := hecStatus{Text: "Success", Code: 0}
+	if he.Ack {
+		status.AckID = &ackID
+	}
+	return hecResponse(http.StatusOK, status)
+}
+
+// authorize checks the token of the request. It returns the error response and false if it is not valid.
+func (he *HECEndpoint) authorize(req *HTTPRequest) (HTTPResponse, bool) {
+	auth := req.Header.Get("Authorization")
+	if auth == "" {
+		return hecResponse(http.StatusUnauthorized, hecStatus{Text: "Token is required", Code: 2}), false
+	}
+	if !strings.HasPrefix(auth, "Splunk ") {
+		return hecResponse(http.StatusUnauthorized, hecStatus{Text: "Invalid authorization", Code: 3}), false
+	}
+	if len(he.Tokens) == 0 {
+		return HTTPResponse{}, true
+	}
+
+	token := strings.TrimPrefix(auth, "Splunk ")
+	for _, t := range he.Tokens {
+		if t == token {
+			return HTTPResponse{}, true
+		}
+	}
+	return hecResponse(http.StatusForbidden, hecStatus{Text: "Invalid token", Code: 4}), false
+}
+
+// nextAckID returns a new acknowledgement identifier in the channel. First one is 0 like in Splunk.
+func (he *HECEndpoint) nextAckID(channel string) uint64 {
+	he.mtx.Lock()
+	defer he.mtx.Unlock()
+	if he.acks == nil {
+		he.acks = make(map[string]uint64)
+	}
+	id := he.acks[channel]
+	he.acks[channel] = id + 1
+	return id
+}
+
+// handleAck returns the status of the acknowledgements requested. All the acknowledgements returned in the
+// channel are reported as indexed.
+func (he *HECEndpoint) handleAck(channel string, body []byte) HTTPResponse {
+	var query struct {
+		Acks []uint64 `json:"acks"`
+	}
+	err := json.Unmarshal(body, &query)
+	if err != nil {
+		return hecResponse(http.StatusBadRequest, hecStatus{Text: "Invalid data format", Code: 6})
+	}
+
+	he.mtx.Lock()
+	issued := he.acks[channel]
+	he.mtx.Unlock()
+
+	acks := make(map[string]bool, len(query.Acks))
+	for _, id := range query.Acks {
+		acks[strconv.FormatUint(id, 10)] = id < issued
+	}
+
+	// map of booleans is always encoded without errors
+	data, _ := json.Marshal(map[string]interface{}{"acks": acks})
+	return jsonResponse(http.StatusOK, data)
+}
+
+func hecResponse(status int, body hecStatus) HTTPResponse {
+	// hecStatus is always encoded without errors
+	data, _ := json.Marshal(body)
+	return jsonResponse(status, data)
+}
+
+func jsonResponse(status int, body []byte) HTTPResponse {
+	return HTTPResponse{
+		Status: status,
+		Header: http.Header{"Content-Type": []string{"application/json"}},
+		Body:   body,
+	}
+}
+
+// HECRecord is a record saved by a HECEndpoint.
+type HECRecord struct {
+	Record
+	// Event is the event received.
+	Event *HECEvent
+}
+
+// HECRecords returns the events received by HEC endpoints in arrival order.
+func (ps *PayloadStorage) HECRecords() []HECRecord {
+	var r []HECRecord
+	for _, rec := range ps.Records() {
+		if event, ok := rec.Value.(*HECEvent); ok {
+			r = append(r, HECRecord{Record: rec, Event: event})
+		}
+	}
+	return r
+}
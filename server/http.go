@@ -0,0 +1,477 @@
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPRequest is a request received by an HTTPListener. It is saved as the Value of the records.
+type HTTPRequest struct {
+	// Method is the HTTP method.
+	Method string
+	// Path is the path of the requested URL.
+	Path string
+	// Query is the query of the requested URL.
+	Query url.Values
+	// Header is the list of request headers.
+	Header http.Header
+	// Host is the host requested.
+	Host string
+	// Body is the request body, decoded if it was compressed with gzip and HTTPListener.DecodeGzip is true.
+	Body []byte
+	// PeerCertificates are the certificates sent by the client in TLS connections.
+	PeerCertificates []*x509.Certificate
+}
+
+// HTTPResponse is the response sent to the clients of an HTTPListener.
+type HTTPResponse struct {
+	// Status is the status code. http.StatusOK is used if it is not defined.
+	Status int
+	// Header is the list of response headers.
+	Header http.Header
+	// Body is the response body.
+	Body []byte
+}
+
+// HTTPRoute is the response to the requests that match Method and Path.
+type HTTPRoute struct {
+	// Method is the HTTP method of the requests. Any method matches if it is empty.
+	Method string
+	// Path is the pattern of the requested path, with the syntax of path.Match. For example /api/* or /hook.
+	Path string
+	// Response is the response sent to the requests matched. It is not used if Endpoint is defined.
+	Response HTTPResponse
+	// Endpoint decodes the requests matched, saves the items and generates the response. If it is nil, the body
+	// of each request is saved as a record and Response is sent.
+	Endpoint HTTPEndpoint
+}
+
+// HTTPEndpoint emulates an HTTP API. See HECEndpoint and BulkEndpoint.
+type HTTPEndpoint interface {
+	// Handle decodes req, calls save for each item received with its data and decoded value, so each item is
+	// saved as a record, and returns the response to the client. Handle must be safe for concurrent use.
+	Handle(req *HTTPRequest, save func(data []byte, value interface{})) HTTPResponse
+}
+
+// HTTPListener is a server that saves each HTTP request received as a record: the body is the record data and
+// the request details are saved as a *HTTPRequest in the record value. Requests of routes with Endpoint are saved
+// by the endpoint instead. The payloads are saved with the same key as TLSListener. TLS is enabled only if CertPem
+// or CertFile are defined, with the same settings as TLSListener. Framer and Responder are not used.
+type HTTPListener struct {
+	TLSListener
+
+	// Routes are the responses to the requests. The first route matched is used.
+	Routes []HTTPRoute
+	// DefaultResponse is the response to the requests that do not match any route.
+	DefaultResponse HTTPResponse
+	// DecodeGzip enables the decompression of the request bodies with Content-Encoding gzip.
+	DecodeGzip bool
+
+	server *http.Server
+	queue  *connQueue
+}
+
+// Start starts the server (listener) and enable the input data processing.
+func (hl *HTTPListener) Start() error {
+	if hl.Address == "" {
+		hl.Address = DefaultListenAddressListener
+	}
+
+	netType, addr, err := splitAddress(hl.Address)
+	if err != nil {
+		return fmt.Errorf("while extracts protocol, address and port: %w", err)
+	}
+
+	hl.tlsConfig = nil
+	if hl.tlsEnabled() {
+		hl.tlsConfig, err = hl.buildTLSConfig()
+		if err != nil {
+			return err
+		}
+	}
+
+	hl.listener, err = net.Listen(netType, addr)
+	if err != nil {
+		return fmt.Errorf("while starts listener: %w, '%s' '%s'", err, netType, addr)
+	}
+
+	// Default values
+	if hl.Address == DefaultListenAddressListener {
+		hl.Address = fmt.Sprintf("tcp://localhost:%d", hl.Port())
+	}
+	hl.Init()
+	if hl.MaxConnections <= 0 {
+		hl.MaxConnections = DefaultMaxConnections
+	}
+	if hl.StopTimeout == 0 {
+		hl.StopTimeout = DefaultSopTimeout
+	}
+
+	// Connections are admitted by the connection manager and passed to the HTTP server through the queue.
+	hl.queue = newConnQueue(hl.listener.Addr())
+	hl.server = &http.Server{
+		Handler: http.HandlerFunc(hl.handleRequest),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, httpConnKey{}, c)
+		},
+	}
+
+	// Start the server to accept connection
+	hl.setStarted()
+	hl.watchCertFiles(hl.stopped)
+	go hl.acceptLoop(hl.handleIncomingHTTPConnection)
+	go hl.serve(hl.server, hl.queue)
+
+	return nil
+}
+
+// StartContext starts the server like Start, and stops it when ctx is done.
+func (hl *HTTPListener) StartContext(ctx context.Context) error {
+	err := hl.Start()
+	if err != nil {
+		return err
+	}
+
+	stopWhenDone(ctx, hl.stopped, hl.Stop)
+	return nil
+}
+
+// Stop stops the listener, no more connections will be allowed and data processing is stopped. It is like Shutdown
+// waiting StopTimeout at most for the active requests.
+func (hl *HTTPListener) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), hl.StopTimeout)
+	defer cancel()
+
+	err := hl.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("stop timeout %v reached while wait for stopping", hl.StopTimeout)
+	}
+	return err
+}
+
+// Shutdown stops the server gracefully: the listener is closed after acceptDrainIdle, idle connections are closed
+// and active requests are completed. When ctx is done, remaining connections are closed with CloseReasonServerStop,
+// and ctx error is returned.
+func (hl *HTTPListener) Shutdown(ctx context.Context) error {
+	if !hl.setStopping() {
+		return nil
+	}
+
+	err := hl.closeListener(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = hl.server.Shutdown(ctx)
+	if err == nil {
+		// Connections admitted but not passed to the HTTP server yet
+		err = hl.connsChanged.waitUntil(ctx, func() bool { return hl.Connections() <= 0 })
+	}
+	if err != nil {
+		hl.closeActiveConns()
+		return err
+	}
+
+	return nil
+}
+
+// URL returns the base URL of the server, for example http://localhost:8080
+func (hl *HTTPListener) URL() string {
+	scheme := "http"
+	if hl.tlsEnabled() {
+		scheme = "https"
+	}
+	return scheme + "://" + strings.TrimPrefix(hl.Address, "tcp://")
+}
+
+func (hl *HTTPListener) serve(server *http.Server, queue *connQueue) {
+	err := server.Serve(queue)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Println("while serve HTTP requests:", err)
+	}
+}
+
+func (hl *HTTPListener) handleIncomingHTTPConnection(rawConn net.Conn) {
+	ce, ok := hl.admitConn(rawConn)
+	if !ok {
+		return
+	}
+
+	clientID := ce.info.RemoteAddr
+	if hl.tlsConfig != nil {
+		var err error
+		clientID, err = hl.handshake(ce)
+		if err != nil {
+			log.Println("Error while make handshake:", err)
+			hl.closeConn(ce, CloseReasonError, err)
+			return
+		}
+	}
+
+	hl.activeConnsMtx.Lock()
+	ce.info.ClientID = clientID
+	hl.activeConnsMtx.Unlock()
+
+	hc := &httpConn{
+		Conn:   ce.conn,
+		reader: connReader{scm: &hl.ConnectionMgr, ce: ce},
+		done:   make(chan struct{}),
+	}
+	if !hl.queue.push(hc) {
+		_ = hc.Close()
+	}
+
+	// Connection slot is released when the HTTP server closes the connection
+	<-hc.done
+}
+
+func (hl *HTTPListener) handleRequest(w http.ResponseWriter, r *http.Request) {
+	hc := r.Context().Value(httpConnKey{}).(*httpConn)
+	ce := hc.reader.ce
+
+	body, status, err := hl.readBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	req := &HTTPRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header,
+		Host:   r.Host,
+		Body:   body,
+	}
+	if tlsConn, ok := ce.conn.(*tls.Conn); ok {
+		req.PeerCertificates = tlsConn.ConnectionState().PeerCertificates
+	}
+
+	route, found := hl.routeFor(r)
+	if found && route.Endpoint != nil {
+		resp := route.Endpoint.Handle(req, func(data []byte, value interface{}) {
+			hl.saveValue(&hl.PayloadStorage, ce, data, value)
+		})
+		hl.writeHTTPResponse(w, resp)
+		return
+	}
+
+	hl.saveValue(&hl.PayloadStorage, ce, body, req)
+	if !found {
+		hl.writeHTTPResponse(w, hl.DefaultResponse)
+		return
+	}
+	hl.writeHTTPResponse(w, route.Response)
+}
+
+// readBody returns the request body, decoded if it is needed. If there is an error, the status code to respond is
+// returned too.
+func (hl *HTTPListener) readBody(r *http.Request) ([]byte, int, error) {
+	maxSize := hl.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+
+	var reader io.Reader = r.Body
+	if hl.DecodeGzip && strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("while decode gzip body: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("while read body: %w", err)
+	}
+	if len(body) > maxSize {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("body is bigger than %d bytes", maxSize)
+	}
+
+	return body, http.StatusOK, nil
+}
+
+// routeFor returns the first route that matches the request. Returned bool is false if no route matches.
+func (hl *HTTPListener) routeFor(r *http.Request) (HTTPRoute, bool) {
+	for _, route := range hl.Routes {
+		if route.Method != "" && !strings.EqualFold(route.Method, r.Method) {
+			continue
+		}
+		if ok, _ := path.Match(route.Path, r.URL.Path); ok {
+			return route, true
+		}
+	}
+	return HTTPRoute{}, false
+}
+
+func (hl *HTTPListener) writeHTTPResponse(w http.ResponseWriter, resp HTTPResponse) {
+	for k, values := range resp.Header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+
+	_, err := w.Write(resp.Body)
+	if err != nil {
+		log.Println("while write HTTP response:", err)
+	}
+}
+
+// setRetryAfter sets the Retry-After header, in seconds rounded up, if d is defined and status is 429 or 503.
+func setRetryAfter(h http.Header, status int, d time.Duration) {
+	if d > 0 && (status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable) {
+		h.Set("Retry-After", strconv.Itoa(int((d+time.Second-1)/time.Second)))
+	}
+}
+
+// HTTPRecord is a record saved by an HTTPListener.
+type HTTPRecord struct {
+	Record
+	// Request is the request received.
+	Request *HTTPRequest
+}
+
+// HTTPRecords returns the requests received by HTTP listeners in arrival order.
+func (ps *PayloadStorage) HTTPRecords() []HTTPRecord {
+	var r []HTTPRecord
+	for _, rec := range ps.Records() {
+		if req, ok := rec.Value.(*HTTPRequest); ok {
+			r = append(r, HTTPRecord{Record: rec, Request: req})
+		}
+	}
+	return r
+}
+
+// httpConnKey is the key of the *httpConn in the context of the requests.
+type httpConnKey struct{}
+
+// httpConn is a connection registered in the connection manager and served by the HTTP server.
+type httpConn struct {
+	net.Conn
+	reader    connReader
+	mtx       sync.Mutex
+	readErr   error
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (hc *httpConn) Read(p []byte) (int, error) {
+	n, err := hc.reader.Read(p)
+	if err != nil {
+		hc.mtx.Lock()
+		hc.readErr = err
+		hc.mtx.Unlock()
+	}
+	return n, err
+}
+
+func (hc *httpConn) Write(p []byte) (int, error) {
+	n, err := hc.Conn.Write(p)
+	scm := hc.reader.scm
+	scm.activeConnsMtx.Lock()
+	hc.reader.ce.info.BytesOut += int64(n)
+	scm.activeConnsMtx.Unlock()
+	return n, err
+}
+
+// Close closes the connection and marks it as closed in the connection manager.
+func (hc *httpConn) Close() error {
+	hc.closeOnce.Do(func() {
+		scm := hc.reader.scm
+
+		hc.mtx.Lock()
+		err := hc.readErr
+		hc.mtx.Unlock()
+
+		// The HTTP server uses read deadlines to abort pending reads, so timeouts are not connection errors
+		var netErr net.Error
+		reason := CloseReasonEOF
+		switch {
+		case err == io.EOF:
+		case errors.Is(err, ErrFaultInjected):
+			reason = CloseReasonFault
+		case scm.isStopping():
+			reason = CloseReasonServerStop
+		case err == nil || (errors.As(err, &netErr) && netErr.Timeout()):
+			// Closed by the HTTP server, for example after a "Connection: close" request
+		default:
+			reason = CloseReasonError
+		}
+		if reason == CloseReasonEOF {
+			err = nil
+		}
+
+		scm.closeConn(hc.reader.ce, reason, err)
+		close(hc.done)
+	})
+	return nil
+}
+
+// connQueue is a net.Listener that returns the connections pushed to it.
+type connQueue struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newConnQueue(addr net.Addr) *connQueue {
+	return &connQueue{
+		addr:   addr,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// push passes the connection to Accept. It returns false if the queue is closed.
+func (cq *connQueue) push(conn net.Conn) bool {
+	select {
+	case cq.conns <- conn:
+		return true
+	case <-cq.closed:
+		return false
+	}
+}
+
+// Accept implements the net.Listener interface.
+func (cq *connQueue) Accept() (net.Conn, error) {
+	select {
+	case conn := <-cq.conns:
+		return conn, nil
+	case <-cq.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements the net.Listener interface.
+func (cq *connQueue) Close() error {
+	cq.closeOnce.Do(func() { close(cq.closed) })
+	return nil
+}
+
+// Addr implements the net.Listener interface.
+func (cq *connQueue) Addr() net.Addr {
+	return cq.addr
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ClientIdentity is the identity of a TLS client: its address and the certificate sent in the handshake. See
+// TLSListener.IdentityFunc.
+type ClientIdentity struct {
+	// RemoteAddr is the source address of the client.
+	RemoteAddr string
+	// Certificates are the certificates sent by the client, leaf first. It is empty if client did not send a
+	// certificate, and then the rest of fields are empty too.
+	Certificates []*x509.Certificate
+	// Subject is the subject of the leaf certificate.
+	Subject pkix.Name
+	// DNSNames, EmailAddresses, IPAddresses and URIs are the subject alternative names of the leaf certificate.
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	// Fingerprint is the SHA-256 fingerprint of the leaf certificate. See CertFingerprint.
+	Fingerprint string
+	// Issuers are the subjects of the issuers of the leaf certificate up to the root CA if the certificate was
+	// verified, or the subjects of the rest of certificates sent by the client otherwise.
+	Issuers []pkix.Name
+}
+
+// IdentityFunc returns the key used to save the payloads received from a client. See TLSListener.IdentityFunc.
+type IdentityFunc func(id *ClientIdentity) string
+
+// CertFingerprint returns the SHA-256 fingerprint of the certificate, in lower case hexadecimal without separators.
+func CertFingerprint(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// newClientIdentity returns the identity of the client of a TLS connection.
+func newClientIdentity(remoteAddr string, cs tls.ConnectionState) *ClientIdentity {
+	id := &ClientIdentity{
+		RemoteAddr:   remoteAddr,
+		Certificates: cs.PeerCertificates,
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return id
+	}
+
+	leaf := cs.PeerCertificates[0]
+	id.Subject = leaf.Subject
+	id.DNSNames = leaf.DNSNames
+	id.EmailAddresses = leaf.EmailAddresses
+	id.IPAddresses = leaf.IPAddresses
+	id.URIs = leaf.URIs
+	id.Fingerprint = CertFingerprint(leaf)
+
+	issuers := cs.PeerCertificates[1:]
+	if len(cs.VerifiedChains) > 0 {
+		issuers = cs.VerifiedChains[0][1:]
+	}
+	for _, c := range issuers {
+		id.Issuers = append(id.Issuers, c.Subject)
+	}
+	return id
+}
+
+// LegacyIdentity is the default IdentityFunc. It returns the subjects of the certificates sent by the client, from
+// the last one to the leaf, separated by "-" and followed by "@" and the remote address, for example
+// "CN=Intermediate CA-CN=client@127.0.0.1:50000". It returns the remote address only if the client did not send a
+// certificate.
+func LegacyIdentity(id *ClientIdentity) string {
+	key := id.RemoteAddr
+	nCerts := len(id.Certificates)
+	if nCerts > 0 {
+		key = "@" + key
+		for i, c := range id.Certificates {
+			key = c.Subject.String() + key
+			if i < nCerts-1 {
+				key = "-" + key
+			}
+		}
+	}
+	return key
+}
+
+// FingerprintIdentity is an IdentityFunc that returns the fingerprint of the client certificate, so the payloads of
+// all the connections of a client are saved together. It returns the remote address if the client did not send a
+// certificate.
+func FingerprintIdentity(id *ClientIdentity) string {
+	if id.Fingerprint == "" {
+		return id.RemoteAddr
+	}
+	return id.Fingerprint
+}
+
+// GetPayloadsByCertCN returns the payloads received from the clients which certificate has the common name, in a
+// map which key is the key used to save them. See IdentityFunc.
+func (tll *TLSListener) GetPayloadsByCertCN(cn string) map[string][]byte {
+	return tll.payloadsByIdentity(func(id *ClientIdentity) bool {
+		return len(id.Certificates) > 0 && id.Subject.CommonName == cn
+	})
+}
+
+// GetPayloadsByFingerprint returns the payloads received from the clients which certificate has the fingerprint, in
+// a map which key is the key used to save them. The fingerprint is case insensitive and it can be separated by ":"
+// like in openssl output. See CertFingerprint.
+func (tll *TLSListener) GetPayloadsByFingerprint(fp string) map[string][]byte {
+	fp = strings.ToLower(strings.ReplaceAll(fp, ":", ""))
+	return tll.payloadsByIdentity(func(id *ClientIdentity) bool {
+		return id.Fingerprint != "" && id.Fingerprint == fp
+	})
+}
+
+// payloadsByIdentity returns the payloads saved with the keys of the connections which client identity matches.
+func (tll *TLSListener) payloadsByIdentity(match func(id *ClientIdentity) bool) map[string][]byte {
+	r := map[string][]byte{}
+	for _, ci := range tll.GetConnections() {
+		if ci.Identity == nil || !match(ci.Identity) {
+			continue
+		}
+		if _, found := r[ci.ClientID]; found {
+			continue
+		}
+		if payload := tll.GetPayload(ci.ClientID); len(payload) > 0 {
+			r[ci.ClientID] = payload
+		}
+	}
+	return r
+}
@@ -0,0 +1,45 @@
+package msgpack_test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+
+	"github.com/cyberluisda/saverserver-go/server/internal/msgpack"
+)
+
+func ExampleDecode() {
+	data, err := msgpack.Append(nil, []interface{}{"tag", int64(-5), map[string]interface{}{"k": []byte("v")}})
+	if err != nil {
+		panic(err)
+	}
+
+	v, n, err := msgpack.Decode(data)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(n == len(data), v)
+
+	//Output:
+	// true [tag -5 map[k:[118]]]
+}
+
+func ExampleReadObject() {
+	stream, _ := msgpack.Append(nil, "first")
+	stream, _ = msgpack.Append(stream, []interface{}{int64(1), int64(2)})
+	r := bufio.NewReader(bytes.NewReader(stream))
+
+	for {
+		obj, err := msgpack.ReadObject(r, 1024)
+		if err != nil {
+			fmt.Println(err)
+			break
+		}
+		fmt.Printf("% x\n", obj)
+	}
+
+	//Output:
+	// a5 66 69 72 73 74
+	// 92 01 02
+	// EOF
+}
@@ -0,0 +1,475 @@
+/*
+Package msgpack implements the subset of MessagePack (https://github.com/msgpack/msgpack/blob/master/spec.md) used
+by the protocols emulated by the servers.
+
+Decoded values are nil, bool, int64, uint64, float64, string (str family), []byte (bin family), []interface{},
+map[string]interface{} and Ext. Map keys that are not strings are formatted with fmt.Sprint.
+*/
+package msgpack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// ErrInvalid is returned when data is not valid MessagePack.
+var ErrInvalid = errors.New("invalid msgpack data")
+
+// ErrTooLarge is returned by ReadObject when an object is bigger than the max size allowed.
+var ErrTooLarge = errors.New("msgpack object too large")
+
+// Ext is a value of an extension type.
+type Ext struct {
+	Type int8
+	Data []byte
+}
+
+// maxDepth is the max nesting level of arrays and maps.
+const maxDepth = 64
+
+// ReadObject reads the next full object from r and returns its encoded bytes. It returns io.EOF if r ends before
+// the first byte and io.ErrUnexpectedEOF if it ends in the middle of the object. Objects bigger than maxSize bytes
+// are not read, and ErrTooLarge is returned.
+func ReadObject(r *bufio.Reader, maxSize int) ([]byte, error) {
+	or := objectReader{r: r, maxSize: maxSize}
+	err := or.read(0)
+	if err == io.EOF && len(or.buf) > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return or.buf, err
+}
+
+type objectReader struct {
+	r       *bufio.Reader
+	buf     []byte
+	maxSize int
+}
+
+// take reads n bytes from the reader to the buffer and returns them.
+func (or *objectReader) take(n int) ([]byte, error) {
+	if len(or.buf)+n > or.maxSize {
+		return nil, ErrTooLarge
+	}
+	start := len(or.buf)
+	for i := 0; i < n; i++ {
+		b, err := or.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		or.buf = append(or.buf, b)
+	}
+	return or.buf[start:], nil
+}
+
+func (or *objectReader) read(depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("%w: too many nested levels", ErrInvalid)
+	}
+
+	head, err := or.take(1)
+	if err != nil {
+		return err
+	}
+
+	lenSize, payload, items, err := describe(head[0])
+	if err != nil {
+		return err
+	}
+
+	if lenSize > 0 {
+		lb, err := or.take(lenSize)
+		if err != nil {
+			return err
+		}
+		n := readUint(lb)
+		if items > 0 {
+			items *= int(n)
+		} else {
+			payload += int(n)
+		}
+	}
+
+	if payload > or.maxSize {
+		return ErrTooLarge
+	}
+	if payload > 0 {
+		_, err = or.take(payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < items; i++ {
+		err = or.read(depth + 1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// describe returns, for the first byte of an object, the size of its length field, the size of the payload
+// without the length and the number of objects contained if it is an array or a map. If there is a length field,
+// items is a multiplier (1 for arrays, 2 for maps) and payload is added to the length read.
+func describe(b byte) (lenSize, payload, items int, err error) {
+	switch {
+	case b <= 0x7f, b >= 0xe0, b == 0xc0, b == 0xc2, b == 0xc3:
+		return 0, 0, 0, nil
+	case b >= 0x80 && b <= 0x8f:
+		return 0, 0, int(b&0x0f) * 2, nil
+	case b >= 0x90 && b <= 0x9f:
+		return 0, 0, int(b & 0x0f), nil
+	case b >= 0xa0 && b <= 0xbf:
+		return 0, int(b & 0x1f), 0, nil
+	}
+
+	switch b {
+	case 0xc4, 0xd9: // bin8, str8
+		return 1, 0, 0, nil
+	case 0xc5, 0xda: // bin16, str16
+		return 2, 0, 0, nil
+	case 0xc6, 0xdb: // bin32, str32
+		return 4, 0, 0, nil
+	case 0xc7: // ext8
+		return 1, 1, 0, nil
+	case 0xc8: // ext16
+		return 2, 1, 0, nil
+	case 0xc9: // ext32
+		return 4, 1, 0, nil
+	case 0xca, 0xce, 0xd2: // float32, uint32, int32
+		return 0, 4, 0, nil
+	case 0xcb, 0xcf, 0xd3: // float64, uint64, int64
+		return 0, 8, 0, nil
+	case 0xcc, 0xd0: // uint8, int8
+		return 0, 1, 0, nil
+	case 0xcd, 0xd1: // uint16, int16
+		return 0, 2, 0, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8: // fixext 1, 2, 4, 8, 16
+		return 0, 1 + (1 << (b - 0xd4)), 0, nil
+	case 0xdc: // array16
+		return 2, 0, 1, nil
+	case 0xdd: // array32
+		return 4, 0, 1, nil
+	case 0xde: // map16
+		return 2, 0, 2, nil
+	case 0xdf: // map32
+		return 4, 0, 2, nil
+	}
+
+	return 0, 0, 0, fmt.Errorf("%w: unknown type 0x%02x", ErrInvalid, b)
+}
+
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, x := range b {
+		v = v<<8 | uint64(x)
+	}
+	return v
+}
+
+// Decode decodes the first object in data. It returns the value and the number of bytes used.
+func Decode(data []byte) (interface{}, int, error) {
+	return decode(data, 0)
+}
+
+// DecodeArrayHeader decodes the header of the array at the beginning of data. It returns the number of elements
+// and the size of the header.
+func DecodeArrayHeader(data []byte) (int, int, error) {
+	if len(data) == 0 {
+		return 0, 0, fmt.Errorf("%w: empty data", ErrInvalid)
+	}
+
+	switch b := data[0]; {
+	case b >= 0x90 && b <= 0x9f:
+		return int(b & 0x0f), 1, nil
+	case b == 0xdc && len(data) >= 3:
+		return int(binary.BigEndian.Uint16(data[1:3])), 3, nil
+	case b == 0xdd && len(data) >= 5:
+		return int(binary.BigEndian.Uint32(data[1:5])), 5, nil
+	}
+	return 0, 0, fmt.Errorf("%w: array expected", ErrInvalid)
+}
+
+// SplitArray returns the encoded elements of the array at the beginning of data, and the total size of the array.
+func SplitArray(data []byte) ([][]byte, int, error) {
+	n, off, err := DecodeArrayHeader(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	elems := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		_, size, err := Decode(data[off:])
+		if err != nil {
+			return nil, 0, err
+		}
+		elems = append(elems, data[off:off+size])
+		off += size
+	}
+	return elems, off, nil
+}
+
+func decode(data []byte, depth int) (interface{}, int, error) {
+	if depth > maxDepth {
+		return nil, 0, fmt.Errorf("%w: too many nested levels", ErrInvalid)
+	}
+	if len(data) == 0 {
+		return nil, 0, fmt.Errorf("%w: unexpected end of data", ErrInvalid)
+	}
+
+	b := data[0]
+	switch {
+	case b <= 0x7f:
+		return int64(b), 1, nil
+	case b >= 0xe0:
+		return int64(int8(b)), 1, nil
+	case b >= 0x80 && b <= 0x8f:
+		return decodeMap(data, 1, int(b&0x0f), depth)
+	case b >= 0x90 && b <= 0x9f:
+		return decodeArray(data, 1, int(b&0x0f), depth)
+	case b >= 0xa0 && b <= 0xbf:
+		v, n, err := payload(data, 1, int(b&0x1f))
+		return string(v), n, err
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, 1, nil
+	case 0xc2:
+		return false, 1, nil
+	case 0xc3:
+		return true, 1, nil
+	case 0xc4, 0xc5, 0xc6:
+		size, off, err := length(data, 1<<(b-0xc4))
+		if err != nil {
+			return nil, 0, err
+		}
+		v, n, err := payload(data, off, size)
+		return append([]byte(nil), v...), n, err
+	case 0xd9, 0xda, 0xdb:
+		size, off, err := length(data, 1<<(b-0xd9))
+		if err != nil {
+			return nil, 0, err
+		}
+		v, n, err := payload(data, off, size)
+		return string(v), n, err
+	case 0xc7, 0xc8, 0xc9:
+		size, off, err := length(data, 1<<(b-0xc7))
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeExt(data, off, size)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return decodeExt(data, 1, 1<<(b-0xd4))
+	case 0xca:
+		v, n, err := payload(data, 1, 4)
+		if err != nil {
+			return nil, 0, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(v))), n, nil
+	case 0xcb:
+		v, n, err := payload(data, 1, 8)
+		if err != nil {
+			return nil, 0, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(v)), n, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, n, err := payload(data, 1, 1<<(b-0xcc))
+		if err != nil {
+			return nil, 0, err
+		}
+		return readUint(v), n, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		v, n, err := payload(data, 1, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		// Sign extension of the value read
+		shift := uint(64 - size*8)
+		return int64(readUint(v)<<shift) >> shift, n, nil
+	case 0xdc, 0xdd:
+		size, off, err := length(data, 2<<(b-0xdc))
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeArray(data, off, size, depth)
+	case 0xde, 0xdf:
+		size, off, err := length(data, 2<<(b-0xde))
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeMap(data, off, size, depth)
+	}
+
+	return nil, 0, fmt.Errorf("%w: unknown type 0x%02x", ErrInvalid, b)
+}
+
+// length reads a length field of lenSize bytes after the first byte. It returns the length and the offset of the
+// data after the field.
+func length(data []byte, lenSize int) (int, int, error) {
+	if len(data) < 1+lenSize {
+		return 0, 0, fmt.Errorf("%w: unexpected end of data", ErrInvalid)
+	}
+	return int(readUint(data[1 : 1+lenSize])), 1 + lenSize, nil
+}
+
+// payload returns size bytes from off and the offset after them.
+func payload(data []byte, off, size int) ([]byte, int, error) {
+	if size < 0 || len(data)-off < size {
+		return nil, 0, fmt.Errorf("%w: unexpected end of data", ErrInvalid)
+	}
+	return data[off : off+size], off + size, nil
+}
+
+func decodeExt(data []byte, off, size int) (interface{}, int, error) {
+	v, n, err := payload(data, off, size+1)
+	if err != nil {
+		return nil, 0, err
+	}
+	return Ext{Type: int8(v[0]), Data: append([]byte(nil), v[1:]...)}, n, nil
+}
+
+func decodeArray(data []byte, off, size int, depth int) (interface{}, int, error) {
+	r := make([]interface{}, 0, minInt(size, len(data)))
+	for i := 0; i < size; i++ {
+		v, n, err := decode(data[off:], depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		r = append(r, v)
+		off += n
+	}
+	return r, off, nil
+}
+
+func decodeMap(data []byte, off, size int, depth int) (interface{}, int, error) {
+	r := make(map[string]interface{}, minInt(size, len(data)))
+	for i := 0; i < size; i++ {
+		k, n, err := decode(data[off:], depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		off += n
+
+		v, n, err := decode(data[off:], depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		off += n
+
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprint(k)
+		}
+		r[key] = v
+	}
+	return r, off, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Append appends the encoding of v to b. Supported types are nil, bool, int, int64, uint64, float64, string,
+// []byte, []interface{}, map[string]interface{} and Ext. Map keys are encoded in sorted order.
+func Append(b []byte, v interface{}) ([]byte, error) {
+	switch x := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if x {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case int:
+		return appendInt(b, int64(x)), nil
+	case int64:
+		return appendInt(b, x), nil
+	case uint64:
+		return append(append(b, 0xcf), uintBytes(x, 8)...), nil
+	case float64:
+		return append(append(b, 0xcb), uintBytes(math.Float64bits(x), 8)...), nil
+	case string:
+		b = appendHeader(b, len(x), 0xa0, 31, [3]byte{0xd9, 0xda, 0xdb})
+		return append(b, x...), nil
+	case []byte:
+		b = appendHeader(b, len(x), 0, -1, [3]byte{0xc4, 0xc5, 0xc6})
+		return append(b, x...), nil
+	case Ext:
+		b = appendHeader(b, len(x.Data), 0, -1, [3]byte{0xc7, 0xc8, 0xc9})
+		b = append(b, byte(x.Type))
+		return append(b, x.Data...), nil
+	case []interface{}:
+		b = appendHeader(b, len(x), 0x90, 15, [3]byte{0, 0xdc, 0xdd})
+		var err error
+		for _, e := range x {
+			b, err = Append(b, e)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = appendHeader(b, len(x), 0x80, 15, [3]byte{0, 0xde, 0xdf})
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var err error
+		for _, k := range keys {
+			b, err = Append(b, k)
+			if err != nil {
+				return nil, err
+			}
+			b, err = Append(b, x[k])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("msgpack encoding of type %T is not supported", v)
+}
+
+func appendInt(b []byte, v int64) []byte {
+	if v >= -32 && v <= 127 {
+		return append(b, byte(v))
+	}
+	return append(append(b, 0xd3), uintBytes(uint64(v), 8)...)
+}
+
+// appendHeader appends the header of a str, bin, ext, array or map with length n. fixCode is used if n <= fixMax,
+// otherwise the first code in codes, formats with 8, 16 and 32 bits length, that can hold n. Zero codes are formats
+// that do not exist for the type.
+func appendHeader(b []byte, n int, fixCode byte, fixMax int, codes [3]byte) []byte {
+	if n <= fixMax {
+		return append(b, fixCode|byte(n))
+	}
+	for i, size := range []int{1, 2, 4} {
+		if codes[i] == 0 || (size < 4 && n >= 1<<(8*size)) {
+			continue
+		}
+		b = append(b, codes[i])
+		return append(b, uintBytes(uint64(n), size)...)
+	}
+	return b
+}
+
+func uintBytes(v uint64, size int) []byte {
+	r := make([]byte, size)
+	for i := size - 1; i >= 0; i-- {
+		r[i] = byte(v)
+		v >>= 8
+	}
+	return r
+}
@@ -0,0 +1,39 @@
+package protowire_test
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/cyberluisda/saverserver-go/server/internal/protowire"
+)
+
+func ExampleParse() {
+	inner := protowire.AppendBytes(nil, 1, []byte("name"))
+	msg := protowire.AppendVarint(nil, 1, 150)
+	msg = protowire.AppendBytes(msg, 2, inner)
+	msg = protowire.AppendFixed64(msg, 3, math.Float64bits(2.5))
+
+	fields, err := protowire.Parse(msg)
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range fields {
+		switch f.Type {
+		case protowire.Varint:
+			fmt.Println(f.Num, f.Int64())
+		case protowire.Fixed64:
+			fmt.Println(f.Num, f.Double())
+		case protowire.Bytes:
+			fmt.Printf("%d % x\n", f.Num, f.Bytes)
+		}
+	}
+
+	_, err = protowire.Parse(msg[0 : len(msg)-1])
+	fmt.Println(err)
+
+	//Output:
+	// 1 150
+	// 2 0a 04 6e 61 6d 65
+	// 3 2.5
+	// invalid protobuf data
+}
@@ -0,0 +1,189 @@
+/*
+Package protowire implements the Protocol Buffers wire format (https://protobuf.dev/programming-guides/encoding/)
+used by the protocols emulated by the servers, without generated code: messages are parsed to the list of their
+fields, and the schema is applied by the caller.
+
+Groups (deprecated wire types 3 and 4) are not supported.
+*/
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// ErrInvalid is returned when data is not valid protobuf.
+var ErrInvalid = errors.New("invalid protobuf data")
+
+// Type is the wire type of a field.
+type Type int8
+
+const (
+	// Varint is the wire type of int32, int64, uint32, uint64, sint32, sint64, bool and enum.
+	Varint Type = 0
+	// Fixed64 is the wire type of fixed64, sfixed64 and double.
+	Fixed64 Type = 1
+	// Bytes is the wire type of string, bytes, embedded messages and packed repeated fields.
+	Bytes Type = 2
+	// Fixed32 is the wire type of fixed32, sfixed32 and float.
+	Fixed32 Type = 5
+)
+
+// Field is a field of a message.
+type Field struct {
+	// Num is the field number.
+	Num int32
+	// Type is the wire type.
+	Type Type
+	// Value is the value of Varint, Fixed64 and Fixed32 fields.
+	Value uint64
+	// Bytes is the value of Bytes fields. It shares the memory with the parsed message.
+	Bytes []byte
+}
+
+// Parse returns the fields of the message in data, in encoding order.
+func Parse(data []byte) ([]Field, error) {
+	var fields []Field
+	for off := 0; off < len(data); {
+		tag, n := binary.Uvarint(data[off:])
+		if n <= 0 || tag>>3 == 0 || tag>>3 > math.MaxInt32 {
+			return nil, ErrInvalid
+		}
+		off += n
+
+		f := Field{Num: int32(tag >> 3), Type: Type(tag & 7)}
+		switch f.Type {
+		case Varint:
+			f.Value, n = binary.Uvarint(data[off:])
+			if n <= 0 {
+				return nil, ErrInvalid
+			}
+			off += n
+		case Fixed64:
+			if len(data)-off < 8 {
+				return nil, ErrInvalid
+			}
+			f.Value = binary.LittleEndian.Uint64(data[off:])
+			off += 8
+		case Fixed32:
+			if len(data)-off < 4 {
+				return nil, ErrInvalid
+			}
+			f.Value = uint64(binary.LittleEndian.Uint32(data[off:]))
+			off += 4
+		case Bytes:
+			size, n := binary.Uvarint(data[off:])
+			if n <= 0 || size > uint64(len(data)-off-n) {
+				return nil, ErrInvalid
+			}
+			off += n
+			f.Bytes = data[off : off+int(size)]
+			off += int(size)
+		default:
+			return nil, ErrInvalid
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// Int64 returns the value of int64, sfixed64, int32 and sfixed32 fields.
+func (f Field) Int64() int64 {
+	if f.Type == Fixed32 {
+		return int64(int32(f.Value))
+	}
+	return int64(f.Value)
+}
+
+// Sint64 returns the value of sint64 and sint32 fields (zigzag encoding).
+func (f Field) Sint64() int64 {
+	return int64(f.Value>>1) ^ -int64(f.Value&1)
+}
+
+// Double returns the value of double fields.
+func (f Field) Double() float64 {
+	return math.Float64frombits(f.Value)
+}
+
+// Float returns the value of float fields.
+func (f Field) Float() float32 {
+	return math.Float32frombits(uint32(f.Value))
+}
+
+// Fixed64s returns the values of a repeated fixed64 or double field, packed or not.
+func (f Field) Fixed64s() ([]uint64, error) {
+	switch f.Type {
+	case Fixed64:
+		return []uint64{f.Value}, nil
+	case Bytes:
+		if len(f.Bytes)%8 != 0 {
+			return nil, ErrInvalid
+		}
+		values := make([]uint64, 0, len(f.Bytes)/8)
+		for off := 0; off < len(f.Bytes); off += 8 {
+			values = append(values, binary.LittleEndian.Uint64(f.Bytes[off:]))
+		}
+		return values, nil
+	}
+	return nil, ErrInvalid
+}
+
+// Varints returns the values of a repeated varint field, packed or not.
+func (f Field) Varints() ([]uint64, error) {
+	switch f.Type {
+	case Varint:
+		return []uint64{f.Value}, nil
+	case Bytes:
+		var values []uint64
+		for off := 0; off < len(f.Bytes); {
+			v, n := binary.Uvarint(f.Bytes[off:])
+			if n <= 0 {
+				return nil, ErrInvalid
+			}
+			values = append(values, v)
+			off += n
+		}
+		return values, nil
+	}
+	return nil, ErrInvalid
+}
+
+// AppendVarint appends a Varint field to b. Use uint64(v) for negative int64 values.
+func AppendVarint(b []byte, num int32, v uint64) []byte {
+	b = appendTag(b, num, Varint)
+	return appendUvarint(b, v)
+}
+
+// AppendFixed64 appends a Fixed64 field to b. Use math.Float64bits for double values.
+func AppendFixed64(b []byte, num int32, v uint64) []byte {
+	b = appendTag(b, num, Fixed64)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// AppendFixed32 appends a Fixed32 field to b. Use math.Float32bits for float values.
+func AppendFixed32(b []byte, num int32, v uint32) []byte {
+	b = appendTag(b, num, Fixed32)
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// AppendBytes appends a Bytes field to b: a string, bytes or an embedded message already encoded.
+func AppendBytes(b []byte, num int32, data []byte) []byte {
+	b = appendTag(b, num, Bytes)
+	b = appendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendTag(b []byte, num int32, t Type) []byte {
+	return appendUvarint(b, uint64(num)<<3|uint64(t))
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[0:n]...)
+}
@@ -0,0 +1,29 @@
+package snappy_test
+
+import (
+	"fmt"
+
+	"github.com/cyberluisda/saverserver-go/server/internal/snappy"
+)
+
+func ExampleDecode() {
+	// "abcabcabcabc": literal "abc" and a copy of 9 bytes at offset 3
+	block := []byte{12, 2 << 2, 'a', 'b', 'c', (9-1)<<2 | 2, 3, 0}
+	data, err := snappy.Decode(block, 1024)
+	fmt.Printf("%q %v\n", data, err)
+
+	data, err = snappy.Decode(snappy.Encode([]byte("hello")), 1024)
+	fmt.Printf("%q %v\n", data, err)
+
+	_, err = snappy.Decode(block, 10)
+	fmt.Println(err)
+
+	_, err = snappy.Decode(block[0:len(block)-1], 1024)
+	fmt.Println(err)
+
+	//Output:
+	// "abcabcabcabc" <nil>
+	// "hello" <nil>
+	// snappy data too large
+	// invalid snappy data
+}
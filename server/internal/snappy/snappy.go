@@ -0,0 +1,124 @@
+/*
+Package snappy implements the Snappy block format (https://github.com/google/snappy/blob/main/format_description.txt)
+used by the protocols emulated by the servers. The framing format is not supported.
+*/
+package snappy
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrInvalid is returned when data is not a valid Snappy block.
+var ErrInvalid = errors.New("invalid snappy data")
+
+// ErrTooLarge is returned by Decode when the decoded length is bigger than the max size allowed.
+var ErrTooLarge = errors.New("snappy data too large")
+
+// Decode returns the decoded block. Blocks with a decoded length bigger than maxSize are not decoded, and
+// ErrTooLarge is returned.
+func Decode(src []byte, maxSize int) ([]byte, error) {
+	n, size := binary.Uvarint(src)
+	if size <= 0 {
+		return nil, ErrInvalid
+	}
+	if n > uint64(maxSize) {
+		return nil, ErrTooLarge
+	}
+
+	dst := make([]byte, 0, n)
+	for off := size; off < len(src); {
+		tag := src[off]
+		off++
+
+		var length, offset int
+		switch tag & 3 {
+		case 0:
+			// Literal: length-1 in the tag or in the next 1 to 4 bytes
+			length = int(tag >> 2)
+			if length >= 60 {
+				extra := length - 59
+				if len(src)-off < extra {
+					return nil, ErrInvalid
+				}
+				length = 0
+				for i := extra - 1; i >= 0; i-- {
+					length = length<<8 | int(src[off+i])
+				}
+				off += extra
+			}
+			length++
+			if length <= 0 || len(src)-off < length || len(dst)+length > int(n) {
+				return nil, ErrInvalid
+			}
+			dst = append(dst, src[off:off+length]...)
+			off += length
+			continue
+		case 1:
+			if len(src)-off < 1 {
+				return nil, ErrInvalid
+			}
+			length = 4 + int(tag>>2&7)
+			offset = int(tag&0xe0)<<3 | int(src[off])
+			off++
+		case 2:
+			if len(src)-off < 2 {
+				return nil, ErrInvalid
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[off:]))
+			off += 2
+		case 3:
+			if len(src)-off < 4 {
+				return nil, ErrInvalid
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[off:]))
+			off += 4
+		}
+
+		if offset <= 0 || offset > len(dst) || len(dst)+length > int(n) {
+			return nil, ErrInvalid
+		}
+		// Copies can overlap the bytes they produce, so they are copied one by one
+		start := len(dst) - offset
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+
+	if len(dst) != int(n) {
+		return nil, ErrInvalid
+	}
+	return dst, nil
+}
+
+// maxLiteral is the max length of the literals written by Encode.
+const maxLiteral = 1 << 16
+
+// Encode returns src encoded as a Snappy block. Data is not compressed: it is written as literals, which is valid
+// for any decoder but only useful to build test data.
+func Encode(src []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(src)))
+	dst := append([]byte{}, buf[0:n]...)
+
+	for len(src) > 0 {
+		chunk := src
+		if len(chunk) > maxLiteral {
+			chunk = chunk[0:maxLiteral]
+		}
+		length := len(chunk) - 1
+		switch {
+		case length < 60:
+			dst = append(dst, byte(length)<<2)
+		case length < 1<<8:
+			dst = append(dst, 60<<2, byte(length))
+		default:
+			dst = append(dst, 61<<2, byte(length), byte(length>>8))
+		}
+		dst = append(dst, chunk...)
+		src = src[len(chunk):]
+	}
+	return dst
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// forceCloseTimeout is the max time to wait for connection handlers after connections are forcibly closed.
+const forceCloseTimeout = time.Second
+
+// acceptDrainIdle is the time that stream listeners keep accepting the connections already queued when they are
+// stopping.
+const acceptDrainIdle = time.Millisecond * 10
+
+// packetDrainIdle is the time without new datagrams after that packet listeners consider the socket drained.
+const packetDrainIdle = time.Millisecond * 10
+
+// stopWhenDone calls stop when ctx is done, unless stopped is closed before.
+func stopWhenDone(ctx context.Context, stopped <-chan struct{}, stop func() error) {
+	go func() {
+		select {
+		case <-ctx.Done():
+			err := stop()
+			if err != nil {
+				log.Println("while stop server after context is done:", err)
+			}
+		case <-stopped:
+		}
+	}()
+}
+
+// setStarted marks the connection manager as started.
+func (scm *ConnectionMgr) setStarted() {
+	scm.activeConnsMtx.Lock()
+	defer scm.activeConnsMtx.Unlock()
+	scm.isStarted = true
+	scm.stopping = false
+	scm.stopped = make(chan struct{})
+	scm.slots = make(chan struct{}, scm.MaxConnections)
+}
+
+// StartContext starts the server like Start, and stops it when ctx is done.
+func (lst *Listener) StartContext(ctx context.Context) error {
+	err := lst.Start()
+	if err != nil {
+		return err
+	}
+
+	stopWhenDone(ctx, lst.stopped, lst.Stop)
+	return nil
+}
+
+// StartContext starts the server like Start, and stops it when ctx is done.
+func (tll *TLSListener) StartContext(ctx context.Context) error {
+	err := tll.Start()
+	if err != nil {
+		return err
+	}
+
+	stopWhenDone(ctx, tll.stopped, tll.Stop)
+	return nil
+}
+
+// Shutdown stops the server gracefully: the connections already queued in the listener are accepted during
+// acceptDrainIdle and then the listener is closed, so new connections are refused. Then it waits for active
+// connections are closed by the clients. When ctx is done, the remaining connections are closed with
+// CloseReasonServerStop, and ctx error is returned.
+func (scm *ConnectionMgr) Shutdown(ctx context.Context) error {
+	if !scm.setStopping() {
+		return nil
+	}
+
+	err := scm.closeListener(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = scm.connsChanged.waitUntil(ctx, func() bool { return scm.Connections() <= 0 })
+	if err != nil {
+		scm.closeActiveConns()
+		return err
+	}
+
+	return nil
+}
+
+// setStopping marks the connection manager as stopping. It returns false if it was not started.
+func (scm *ConnectionMgr) setStopping() bool {
+	scm.activeConnsMtx.Lock()
+	defer scm.activeConnsMtx.Unlock()
+	if !scm.isStarted {
+		return false
+	}
+	scm.isStarted = false
+	scm.stopping = true
+	close(scm.stopped)
+	return true
+}
+
+// closeListener closes the listener after acceptDrainIdle, or when ctx is done, so connections queued before
+// stopping are not lost. If ctx is done before, active connections are closed too and ctx error is returned.
+func (scm *ConnectionMgr) closeListener(ctx context.Context) error {
+	timer := time.NewTimer(acceptDrainIdle)
+	defer timer.Stop()
+
+	var errDrain error
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		errDrain = ctx.Err()
+	}
+
+	err := scm.listener.Close()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		err = fmt.Errorf("while close the listener: %w", err)
+	} else {
+		err = nil
+	}
+
+	if errDrain != nil {
+		scm.closeActiveConns()
+		return errDrain
+	}
+
+	return err
+}
+
+// closeActiveConns closes all active connections and waits for their handlers ends.
+func (scm *ConnectionMgr) closeActiveConns() {
+	scm.activeConnsMtx.Lock()
+	for _, ce := range scm.connLog {
+		if ce.info.Active() {
+			err := ce.conn.Close()
+			if err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Println("while close connection:", err)
+			}
+		}
+	}
+	scm.activeConnsMtx.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), forceCloseTimeout)
+	defer cancel()
+	err := scm.connsChanged.waitUntil(ctx, func() bool { return scm.Connections() <= 0 })
+	if err != nil {
+		log.Println("while wait for closed connections are released:", err)
+	}
+}
+
+// StartContext starts the server like Start, and stops it when ctx is done.
+func (lp *ListenerPacket) StartContext(ctx context.Context) error {
+	err := lp.Start()
+	if err != nil {
+		return err
+	}
+
+	stopWhenDone(ctx, lp.stopped, lp.Stop)
+	return nil
+}
+
+// Shutdown stops the server gracefully: the datagrams already queued in the socket are read until no more data
+// is received or ctx is done. Then the socket is closed.
+func (lp *ListenerPacket) Shutdown(ctx context.Context) error {
+	lp.mtx.Lock()
+	if !lp.started {
+		lp.mtx.Unlock()
+		return nil
+	}
+	lp.started = false
+	lp.draining = true
+	close(lp.stopped)
+	lp.mtx.Unlock()
+
+	// Reader ends when socket is idle after the deadline.
+	err := lp.conn.SetReadDeadline(time.Now().Add(packetDrainIdle))
+	if err != nil {
+		log.Println("while set read deadline on packet connection:", err)
+	}
+
+	var errDrain error
+	select {
+	case <-lp.readerDone:
+	case <-ctx.Done():
+		errDrain = ctx.Err()
+	}
+
+	err = lp.conn.Close()
+	if err != nil {
+		return fmt.Errorf("while close packet connection: %w", err)
+	}
+
+	<-lp.readerDone
+	return errDrain
+}
@@ -0,0 +1,1122 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cyberluisda/saverserver-go/server/internal/protowire"
+)
+
+const (
+	// OTLPLogsPath is the path of the OTLP/HTTP endpoint for logs.
+	OTLPLogsPath = "/v1/logs"
+	// OTLPTracesPath is the path of the OTLP/HTTP endpoint for traces.
+	OTLPTracesPath = "/v1/traces"
+	// OTLPMetricsPath is the path of the OTLP/HTTP endpoint for metrics.
+	OTLPMetricsPath = "/v1/metrics"
+)
+
+// OTLPSignal is the kind of telemetry data of an OTLP export request.
+type OTLPSignal string
+
+const (
+	// OTLPLogs are the requests to OTLPLogsPath.
+	OTLPLogs OTLPSignal = "logs"
+	// OTLPTraces are the requests to OTLPTracesPath.
+	OTLPTraces OTLPSignal = "traces"
+	// OTLPMetrics are the requests to OTLPMetricsPath.
+	OTLPMetrics OTLPSignal = "metrics"
+)
+
+// OTLPExport is an export request received by an OTLPEndpoint. It is saved as the Value of the records, and the
+// request body is saved as the record Data. The log records, spans or data points of the request are saved in the
+// records that follow it.
+type OTLPExport struct {
+	// Signal is the kind of data exported.
+	Signal OTLPSignal
+	// Encoding is "protobuf" or "json".
+	Encoding string
+	// Status is the HTTP status code of the response.
+	Status int
+	// Items is the number of log records, spans or data points in the request.
+	Items int
+	// Rejected is the number of items rejected in a partial success response. They are not saved.
+	Rejected int
+	// Err is the error found while decode the request, if any.
+	Err error
+}
+
+// OTLPScope is the instrumentation scope of the OTLP data.
+type OTLPScope struct {
+	Name    string
+	Version string
+}
+
+// OTLPLog is a log record received by an OTLPEndpoint. It is saved as the Value of the records, and the encoded
+// log record is saved as the record Data.
+type OTLPLog struct {
+	// Resource are the attributes of the resource that emitted the log.
+	Resource map[string]interface{}
+	// Scope is the instrumentation scope.
+	Scope OTLPScope
+	// Time is the time of the event. It is zero if it is not defined.
+	Time time.Time
+	// ObservedTime is the time when the event was observed. It is zero if it is not defined.
+	ObservedTime time.Time
+	// SeverityNumber is the numerical severity, from 1 (TRACE) to 24 (FATAL4).
+	SeverityNumber int
+	// SeverityText is the severity as it was known at the source.
+	SeverityText string
+	// Body is the body of the log: string, bool, int64, float64, []byte, []interface{} or map[string]interface{}.
+	Body interface{}
+	// Attributes are the attributes of the log.
+	Attributes map[string]interface{}
+	// TraceID is the hex encoded trace ID, if it is defined.
+	TraceID string
+	// SpanID is the hex encoded span ID, if it is defined.
+	SpanID string
+	// Flags are the trace flags.
+	Flags uint32
+	// EventName is the name of the event, if the log is an event.
+	EventName string
+}
+
+// OTLPSpan is a span received by an OTLPEndpoint. It is saved as the Value of the records, and the encoded span is
+// saved as the record Data.
+type OTLPSpan struct {
+	// Resource are the attributes of the resource that emitted the span.
+	Resource map[string]interface{}
+	// Scope is the instrumentation scope.
+	Scope OTLPScope
+	// TraceID is the hex encoded trace ID.
+	TraceID string
+	// SpanID is the hex encoded span ID.
+	SpanID string
+	// ParentSpanID is the hex encoded ID of the parent span. It is empty for root spans.
+	ParentSpanID string
+	// TraceState is the W3C trace state.
+	TraceState string
+	// Name is the span name.
+	Name string
+	// Kind is the span kind: 1 internal, 2 server, 3 client, 4 producer and 5 consumer.
+	Kind int
+	// Start is the start time of the span.
+	Start time.Time
+	// End is the end time of the span.
+	End time.Time
+	// Attributes are the attributes of the span.
+	Attributes map[string]interface{}
+	// Events are the events of the span.
+	Events []OTLPSpanEvent
+	// StatusCode is the span status: 0 unset, 1 ok and 2 error.
+	StatusCode int
+	// StatusMessage is the status description.
+	StatusMessage string
+}
+
+// OTLPSpanEvent is an event of an OTLPSpan.
+type OTLPSpanEvent struct {
+	Time       time.Time
+	Name       string
+	Attributes map[string]interface{}
+}
+
+// OTLPMetricType is the type of the metric of a data point.
+type OTLPMetricType int
+
+const (
+	// OTLPGauge is a gauge metric.
+	OTLPGauge OTLPMetricType = iota + 1
+	// OTLPSum is a sum (counter) metric.
+	OTLPSum
+	// OTLPHistogram is a histogram metric with explicit buckets.
+	OTLPHistogram
+	// OTLPExponentialHistogram is a histogram metric with exponential buckets.
+	OTLPExponentialHistogram
+	// OTLPSummary is a summary metric.
+	OTLPSummary
+)
+
+// String returns a text representation of the type.
+func (mt OTLPMetricType) String() string {
+	switch mt {
+	case OTLPGauge:
+		return "gauge"
+	case OTLPSum:
+		return "sum"
+	case OTLPHistogram:
+		return "histogram"
+	case OTLPExponentialHistogram:
+		return "exponential histogram"
+	case OTLPSummary:
+		return "summary"
+	}
+	return "unknown"
+}
+
+// OTLPDataPoint is a metric data point received by an OTLPEndpoint. It is saved as the Value of the records, and
+// the encoded data point is saved as the record Data.
+type OTLPDataPoint struct {
+	// Resource are the attributes of the resource that emitted the metric.
+	Resource map[string]interface{}
+	// Scope is the instrumentation scope.
+	Scope OTLPScope
+	// Metric is the metric name.
+	Metric string
+	// Description is the metric description.
+	Description string
+	// Unit is the metric unit.
+	Unit string
+	// Type is the metric type.
+	Type OTLPMetricType
+	// Temporality is the aggregation temporality of sums and histograms: 1 delta and 2 cumulative.
+	Temporality int
+	// Monotonic is true for monotonic sums.
+	Monotonic bool
+	// Attributes are the attributes of the data point.
+	Attributes map[string]interface{}
+	// Start is the start time of the aggregation. It is zero if it is not defined.
+	Start time.Time
+	// Time is the time of the data point.
+	Time time.Time
+	// Value is the value of gauges and sums.
+	Value float64
+	// Count is the number of values of histograms and summaries.
+	Count uint64
+	// Sum is the sum of the values of histograms and summaries.
+	Sum float64
+	// BucketCounts are the counts of each bucket of histograms.
+	BucketCounts []uint64
+	// ExplicitBounds are the bucket bounds of histograms.
+	ExplicitBounds []float64
+}
+
+// OTLPEndpoint emulates an OpenTelemetry collector OTLP/HTTP receiver for logs, traces and metrics, with protobuf
+// ("application/x-protobuf") and JSON ("application/json") encodings. See OTLPLogsPath, OTLPTracesPath and
+// OTLPMetricsPath. Enable HTTPListener.DecodeGzip for clients that compress the requests.
+type OTLPEndpoint struct {
+	// Fail returns the HTTP status code to respond to the export request instead of success, or 0 to accept it.
+	// Failed requests are saved, but their items are not. No request fails if it is nil.
+	Fail func(export *OTLPExport) int
+	// RetryAfter is sent in the Retry-After header of 429 and 503 responses if it is defined.
+	RetryAfter time.Duration
+	// Reject returns true if the item number n (starting from 0) in the request must be rejected: an
+	// *OTLPLog, *OTLPSpan or *OTLPDataPoint. Rejected items are not saved and they are reported in a partial
+	// success response. No item is rejected if it is nil.
+	Reject func(n int, item interface{}) bool
+}
+
+// Routes returns the routes to use the endpoint in an HTTPListener.
+func (oe *OTLPEndpoint) Routes() []HTTPRoute {
+	return []HTTPRoute{
+		{Method: http.MethodPost, Path: OTLPLogsPath, Endpoint: oe},
+		{Method: http.MethodPost, Path: OTLPTracesPath, Endpoint: oe},
+		{Method: http.MethodPost, Path: OTLPMetricsPath, Endpoint: oe},
+	}
+}
+
+// otlpItem is a log record, span or data point of an export request.
+type otlpItem struct {
+	data  []byte
+	value interface{}
+}
+
+// Handle implements the HTTPEndpoint interface.
+func (oe *OTLPEndpoint) Handle(req *HTTPRequest, save func(data []byte, value interface{})) HTTPResponse {
+	export := &OTLPExport{}
+	switch req.Path {
+	case OTLPLogsPath:
+		export.Signal = OTLPLogs
+	case OTLPTracesPath:
+		export.Signal = OTLPTraces
+	case OTLPMetricsPath:
+		export.Signal = OTLPMetrics
+	default:
+		return otlpStatusResponse(http.StatusNotFound, "", "unknown OTLP path "+req.Path)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-protobuf":
+		export.Encoding = "protobuf"
+	case "application/json":
+		export.Encoding = "json"
+	default:
+		return otlpStatusResponse(http.StatusUnsupportedMediaType, "", "unsupported content type "+mediaType)
+	}
+
+	var items []otlpItem
+	if export.Encoding == "json" {
+		items, export.Err = decodeOTLPJSON(export.Signal, req.Body)
+	} else {
+		items, export.Err = decodeOTLPProto(export.Signal, req.Body)
+	}
+	if export.Err != nil {
+		export.Status = http.StatusBadRequest
+		save(req.Body, export)
+		return otlpStatusResponse(export.Status, export.Encoding, export.Err.Error())
+	}
+	export.Items = len(items)
+
+	if oe.Fail != nil {
+		export.Status = oe.Fail(export)
+	}
+	if export.Status != 0 && export.Status != http.StatusOK {
+		save(req.Body, export)
+		resp := otlpStatusResponse(export.Status, export.Encoding, http.StatusText(export.Status))
+		setRetryAfter(resp.Header, export.Status, oe.RetryAfter)
+		return resp
+	}
+	export.Status = http.StatusOK
+
+	var accepted []otlpItem
+	for n, item := range items {
+		if oe.Reject != nil && oe.Reject(n, item.value) {
+			export.Rejected++
+			continue
+		}
+		accepted = append(accepted, item)
+	}
+
+	save(req.Body, export)
+	for _, item := range accepted {
+		save(item.data, item.value)
+	}
+
+	return otlpSuccessResponse(export)
+}
+
+// otlpSuccessResponse returns the Export*ServiceResponse, with the partial success if any item was rejected.
+func otlpSuccessResponse(export *OTLPExport) HTTPResponse {
+	message := ""
+	if export.Rejected > 0 {
+		message = fmt.Sprintf("%d of %d items rejected", export.Rejected, export.Items)
+	}
+
+	if export.Encoding == "json" {
+		body := map[string]interface{}{}
+		if export.Rejected > 0 {
+			rejectedKey := map[OTLPSignal]string{
+				OTLPLogs:    "rejectedLogRecords",
+				OTLPTraces:  "rejectedSpans",
+				OTLPMetrics: "rejectedDataPoints",
+			}[export.Signal]
+			body["partialSuccess"] = map[string]string{
+				rejectedKey:    strconv.Itoa(export.Rejected),
+				"errorMessage": message,
+			}
+		}
+		// map of strings is always encoded without errors
+		data, _ := json.Marshal(body)
+		return jsonResponse(http.StatusOK, data)
+	}
+
+	var body []byte
+	if export.Rejected > 0 {
+		partial := protowire.AppendVarint(nil, 1, uint64(export.Rejected))
+		partial = protowire.AppendBytes(partial, 2, []byte(message))
+		body = protowire.AppendBytes(nil, 1, partial)
+	}
+	return protobufResponse(http.StatusOK, body)
+}
+
+// otlpStatusResponse returns a google.rpc.Status error response in the encoding of the request, or JSON if it is
+// not known.
+func otlpStatusResponse(status int, encoding, message string) HTTPResponse {
+	code := 2 // Unknown
+	switch status {
+	case http.StatusBadRequest:
+		code = 3 // InvalidArgument
+	case http.StatusNotFound:
+		code = 5 // NotFound
+	case http.StatusTooManyRequests:
+		code = 8 // ResourceExhausted
+	case http.StatusServiceUnavailable:
+		code = 14 // Unavailable
+	}
+
+	if encoding == "protobuf" {
+		body := protowire.AppendVarint(nil, 1, uint64(code))
+		body = protowire.AppendBytes(body, 2, []byte(message))
+		return protobufResponse(status, body)
+	}
+
+	// struct of string and int is always encoded without errors
+	data, _ := json.Marshal(struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}{code, message})
+	return jsonResponse(status, data)
+}
+
+func protobufResponse(status int, body []byte) HTTPResponse {
+	return HTTPResponse{
+		Status: status,
+		Header: http.Header{"Content-Type": []string{"application/x-protobuf"}},
+		Body:   body,
+	}
+}
+
+// otlpContext is the resource and scope of the items.
+type otlpContext struct {
+	resource map[string]interface{}
+	scope    OTLPScope
+}
+
+// decodeOTLPProto decodes an Export*ServiceRequest. All signals share the structure
+// request{1: resource group{1: resource, 2: scope group{1: scope, 2: item}}}.
+func decodeOTLPProto(signal OTLPSignal, body []byte) ([]otlpItem, error) {
+	decodeItem := map[OTLPSignal]func(otlpContext, []byte) ([]otlpItem, error){
+		OTLPLogs:    decodeOTLPLogProto,
+		OTLPTraces:  decodeOTLPSpanProto,
+		OTLPMetrics: decodeOTLPMetricProto,
+	}[signal]
+
+	var items []otlpItem
+	err := forEachProtoField(body, 1, func(group []byte) error {
+		var ctx otlpContext
+		return forEachProtoMessage(group, func(f protowire.Field) error {
+			switch f.Num {
+			case 1:
+				return forEachProtoField(f.Bytes, 1, func(kv []byte) error {
+					return addProtoAttribute(&ctx.resource, kv)
+				})
+			case 2:
+				// Scope is encoded before the items
+				scoped := ctx
+				return forEachProtoMessage(f.Bytes, func(f protowire.Field) error {
+					switch f.Num {
+					case 1:
+						var err error
+						scoped.scope, err = decodeOTLPScopeProto(f.Bytes)
+						return err
+					case 2:
+						decoded, err := decodeItem(scoped, f.Bytes)
+						items = append(items, decoded...)
+						return err
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("while decode OTLP %s protobuf request: %w", signal, err)
+	}
+	return items, nil
+}
+
+// forEachProtoMessage calls fn for each field of the message.
+func forEachProtoMessage(data []byte, fn func(protowire.Field) error) error {
+	fields, err := protowire.Parse(data)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		err = fn(f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEachProtoField calls fn for each value of the embedded message field num.
+func forEachProtoField(data []byte, num int32, fn func([]byte) error) error {
+	return forEachProtoMessage(data, func(f protowire.Field) error {
+		if f.Num != num {
+			return nil
+		}
+		if f.Type != protowire.Bytes {
+			return protowire.ErrInvalid
+		}
+		return fn(f.Bytes)
+	})
+}
+
+func decodeOTLPScopeProto(data []byte) (OTLPScope, error) {
+	var scope OTLPScope
+	err := forEachProtoMessage(data, func(f protowire.Field) error {
+		switch f.Num {
+		case 1:
+			scope.Name = string(f.Bytes)
+		case 2:
+			scope.Version = string(f.Bytes)
+		}
+		return nil
+	})
+	return scope, err
+}
+
+// addProtoAttribute decodes the KeyValue message in data and adds it to attrs.
+func addProtoAttribute(attrs *map[string]interface{}, data []byte) error {
+	var key string
+	var value interface{}
+	err := forEachProtoMessage(data, func(f protowire.Field) error {
+		switch f.Num {
+		case 1:
+			key = string(f.Bytes)
+		case 2:
+			var err error
+			value, err = decodeOTLPValueProto(f.Bytes)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if *attrs == nil {
+		*attrs = map[string]interface{}{}
+	}
+	(*attrs)[key] = value
+	return nil
+}
+
+// decodeOTLPValueProto decodes an AnyValue message.
+func decodeOTLPValueProto(data []byte) (interface{}, error) {
+	var value interface{}
+	err := forEachProtoMessage(data, func(f protowire.Field) error {
+		switch f.Num {
+		case 1:
+			value = string(f.Bytes)
+		case 2:
+			value = f.Value != 0
+		case 3:
+			value = f.Int64()
+		case 4:
+			value = f.Double()
+		case 5:
+			values := []interface{}{}
+			value = values
+			return forEachProtoField(f.Bytes, 1, func(v []byte) error {
+				decoded, err := decodeOTLPValueProto(v)
+				values = append(values, decoded)
+				value = values
+				return err
+			})
+		case 6:
+			kvs := map[string]interface{}{}
+			value = kvs
+			return forEachProtoField(f.Bytes, 1, func(kv []byte) error {
+				return addProtoAttribute(&kvs, kv)
+			})
+		case 7:
+			value = append([]byte{}, f.Bytes...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+func decodeOTLPLogProto(ctx otlpContext, data []byte) ([]otlpItem, error) {
+	lr := &OTLPLog{Resource: ctx.resource, Scope: ctx.scope}
+	err := forEachProtoMessage(data, func(f protowire.Field) error {
+		switch f.Num {
+		case 1:
+			lr.Time = otlpTime(f.Value)
+		case 11:
+			lr.ObservedTime = otlpTime(f.Value)
+		case 2:
+			lr.SeverityNumber = int(f.Int64())
+		case 3:
+			lr.SeverityText = string(f.Bytes)
+		case 5:
+			var err error
+			lr.Body, err = decodeOTLPValueProto(f.Bytes)
+			return err
+		case 6:
+			return addProtoAttribute(&lr.Attributes, f.Bytes)
+		case 8:
+			lr.Flags = uint32(f.Value)
+		case 9:
+			lr.TraceID = hex.EncodeToString(f.Bytes)
+		case 10:
+			lr.SpanID = hex.EncodeToString(f.Bytes)
+		case 12:
+			lr.EventName = string(f.Bytes)
+		}
+		return nil
+	})
+	return []otlpItem{{data: data, value: lr}}, err
+}
+
+func decodeOTLPSpanProto(ctx otlpContext, data []byte) ([]otlpItem, error) {
+	span := &OTLPSpan{Resource: ctx.resource, Scope: ctx.scope}
+	err := forEachProtoMessage(data, func(f protowire.Field) error {
+		switch f.Num {
+		case 1:
+			span.TraceID = hex.EncodeToString(f.Bytes)
+		case 2:
+			span.SpanID = hex.EncodeToString(f.Bytes)
+		case 3:
+			span.TraceState = string(f.Bytes)
+		case 4:
+			span.ParentSpanID = hex.EncodeToString(f.Bytes)
+		case 5:
+			span.Name = string(f.Bytes)
+		case 6:
+			span.Kind = int(f.Int64())
+		case 7:
+			span.Start = otlpTime(f.Value)
+		case 8:
+			span.End = otlpTime(f.Value)
+		case 9:
+			return addProtoAttribute(&span.Attributes, f.Bytes)
+		case 11:
+			var event OTLPSpanEvent
+			err := forEachProtoMessage(f.Bytes, func(f protowire.Field) error {
+				switch f.Num {
+				case 1:
+					event.Time = otlpTime(f.Value)
+				case 2:
+					event.Name = string(f.Bytes)
+				case 3:
+					return addProtoAttribute(&event.Attributes, f.Bytes)
+				}
+				return nil
+			})
+			span.Events = append(span.Events, event)
+			return err
+		case 15:
+			return forEachProtoMessage(f.Bytes, func(f protowire.Field) error {
+				switch f.Num {
+				case 2:
+					span.StatusMessage = string(f.Bytes)
+				case 3:
+					span.StatusCode = int(f.Int64())
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return []otlpItem{{data: data, value: span}}, err
+}
+
+// decodeOTLPMetricProto decodes a Metric message. It returns an item for each data point.
+func decodeOTLPMetricProto(ctx otlpContext, data []byte) ([]otlpItem, error) {
+	metric := OTLPDataPoint{Resource: ctx.resource, Scope: ctx.scope}
+	var points [][]byte
+	err := forEachProtoMessage(data, func(f protowire.Field) error {
+		switch f.Num {
+		case 1:
+			metric.Metric = string(f.Bytes)
+		case 2:
+			metric.Description = string(f.Bytes)
+		case 3:
+			metric.Unit = string(f.Bytes)
+		case 5, 7, 9, 10, 11:
+			metric.Type = map[int32]OTLPMetricType{
+				5: OTLPGauge, 7: OTLPSum, 9: OTLPHistogram, 10: OTLPExponentialHistogram, 11: OTLPSummary,
+			}[f.Num]
+			return forEachProtoMessage(f.Bytes, func(f protowire.Field) error {
+				switch f.Num {
+				case 1:
+					points = append(points, f.Bytes)
+				case 2:
+					metric.Temporality = int(f.Int64())
+				case 3:
+					metric.Monotonic = f.Value != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var items []otlpItem
+	for _, p := range points {
+		point := metric
+		err = decodeOTLPDataPointProto(&point, p)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, otlpItem{data: p, value: &point})
+	}
+	return items, nil
+}
+
+// decodeOTLPDataPointProto decodes a NumberDataPoint, HistogramDataPoint, ExponentialHistogramDataPoint or
+// SummaryDataPoint message, depending on the metric type.
+func decodeOTLPDataPointProto(point *OTLPDataPoint, data []byte) error {
+	attributesNum := int32(7)
+	switch point.Type {
+	case OTLPHistogram:
+		attributesNum = 9
+	case OTLPExponentialHistogram:
+		attributesNum = 1
+	}
+
+	return forEachProtoMessage(data, func(f protowire.Field) error {
+		switch {
+		case f.Num == attributesNum:
+			return addProtoAttribute(&point.Attributes, f.Bytes)
+		case f.Num == 2:
+			point.Start = otlpTime(f.Value)
+		case f.Num == 3:
+			point.Time = otlpTime(f.Value)
+		}
+
+		if point.Type == OTLPGauge || point.Type == OTLPSum {
+			switch f.Num {
+			case 4:
+				point.Value = f.Double()
+			case 6:
+				point.Value = float64(f.Int64())
+			}
+			return nil
+		}
+
+		switch f.Num {
+		case 4:
+			point.Count = f.Value
+		case 5:
+			point.Sum = f.Double()
+		}
+		if point.Type != OTLPHistogram {
+			return nil
+		}
+		switch f.Num {
+		case 6:
+			counts, err := f.Fixed64s()
+			point.BucketCounts = append(point.BucketCounts, counts...)
+			return err
+		case 7:
+			bounds, err := f.Fixed64s()
+			for _, b := range bounds {
+				point.ExplicitBounds = append(point.ExplicitBounds, protowire.Field{Value: b}.Double())
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// otlpTime returns the time of the nanoseconds since epoch, or zero time if it is 0.
+func otlpTime(nanos uint64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(nanos)).UTC()
+}
+
+// otlpJSONKeys are the keys of the resource groups, scope groups and items of each signal in JSON encoding.
+var otlpJSONKeys = map[OTLPSignal][3]string{
+	OTLPLogs:    {"resourceLogs", "scopeLogs", "logRecords"},
+	OTLPTraces:  {"resourceSpans", "scopeSpans", "spans"},
+	OTLPMetrics: {"resourceMetrics", "scopeMetrics", "metrics"},
+}
+
+// decodeOTLPJSON decodes an Export*ServiceRequest in the OTLP JSON encoding.
+func decodeOTLPJSON(signal OTLPSignal, body []byte) ([]otlpItem, error) {
+	keys := otlpJSONKeys[signal]
+	var items []otlpItem
+	err := func() error {
+		var request map[string]json.RawMessage
+		err := json.Unmarshal(body, &request)
+		if err != nil {
+			return err
+		}
+
+		var groups []map[string]json.RawMessage
+		err = unmarshalOptional(request[keys[0]], &groups)
+		if err != nil {
+			return err
+		}
+		for _, group := range groups {
+			var ctx otlpContext
+			var resource struct {
+				Attributes otlpAttributes `json:"attributes"`
+			}
+			var scopes []map[string]json.RawMessage
+			err = unmarshalOptional(group["resource"], &resource)
+			if err == nil {
+				err = unmarshalOptional(group[keys[1]], &scopes)
+			}
+			if err != nil {
+				return err
+			}
+			ctx.resource = resource.Attributes
+
+			for _, scope := range scopes {
+				var raws []json.RawMessage
+				err = unmarshalOptional(scope["scope"], &ctx.scope)
+				if err == nil {
+					err = unmarshalOptional(scope[keys[2]], &raws)
+				}
+				if err != nil {
+					return err
+				}
+
+				for _, raw := range raws {
+					decoded, err := decodeOTLPItemJSON(signal, ctx, raw)
+					if err != nil {
+						return err
+					}
+					items = append(items, decoded...)
+				}
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		return nil, fmt.Errorf("while decode OTLP %s JSON request: %w", signal, err)
+	}
+	return items, nil
+}
+
+// unmarshalOptional decodes data in v if it is not empty.
+func unmarshalOptional(data json.RawMessage, v interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+func decodeOTLPItemJSON(signal OTLPSignal, ctx otlpContext, raw json.RawMessage) ([]otlpItem, error) {
+	switch signal {
+	case OTLPLogs:
+		var l struct {
+			TimeUnixNano         otlpUint       `json:"timeUnixNano"`
+			ObservedTimeUnixNano otlpUint       `json:"observedTimeUnixNano"`
+			SeverityNumber       int            `json:"severityNumber"`
+			SeverityText         string         `json:"severityText"`
+			Body                 otlpValue      `json:"body"`
+			Attributes           otlpAttributes `json:"attributes"`
+			Flags                uint32         `json:"flags"`
+			TraceID              string         `json:"traceId"`
+			SpanID               string         `json:"spanId"`
+			EventName            string         `json:"eventName"`
+		}
+		err := json.Unmarshal(raw, &l)
+		return []otlpItem{{data: raw, value: &OTLPLog{
+			Resource:       ctx.resource,
+			Scope:          ctx.scope,
+			Time:           otlpTime(uint64(l.TimeUnixNano)),
+			ObservedTime:   otlpTime(uint64(l.ObservedTimeUnixNano)),
+			SeverityNumber: l.SeverityNumber,
+			SeverityText:   l.SeverityText,
+			Body:           l.Body.value,
+			Attributes:     l.Attributes,
+			TraceID:        strings.ToLower(l.TraceID),
+			SpanID:         strings.ToLower(l.SpanID),
+			Flags:          l.Flags,
+			EventName:      l.EventName,
+		}}}, err
+
+	case OTLPTraces:
+		var s struct {
+			TraceID           string         `json:"traceId"`
+			SpanID            string         `json:"spanId"`
+			TraceState        string         `json:"traceState"`
+			ParentSpanID      string         `json:"parentSpanId"`
+			Name              string         `json:"name"`
+			Kind              int            `json:"kind"`
+			StartTimeUnixNano otlpUint       `json:"startTimeUnixNano"`
+			EndTimeUnixNano   otlpUint       `json:"endTimeUnixNano"`
+			Attributes        otlpAttributes `json:"attributes"`
+			Events            []struct {
+				TimeUnixNano otlpUint       `json:"timeUnixNano"`
+				Name         string         `json:"name"`
+				Attributes   otlpAttributes `json:"attributes"`
+			} `json:"events"`
+			Status struct {
+				Message string `json:"message"`
+				Code    int    `json:"code"`
+			} `json:"status"`
+		}
+		err := json.Unmarshal(raw, &s)
+		span := &OTLPSpan{
+			Resource:      ctx.resource,
+			Scope:         ctx.scope,
+			TraceID:       strings.ToLower(s.TraceID),
+			SpanID:        strings.ToLower(s.SpanID),
+			ParentSpanID:  strings.ToLower(s.ParentSpanID),
+			TraceState:    s.TraceState,
+			Name:          s.Name,
+			Kind:          s.Kind,
+			Start:         otlpTime(uint64(s.StartTimeUnixNano)),
+			End:           otlpTime(uint64(s.EndTimeUnixNano)),
+			Attributes:    s.Attributes,
+			StatusCode:    s.Status.Code,
+			StatusMessage: s.Status.Message,
+		}
+		for _, e := range s.Events {
+			span.Events = append(span.Events, OTLPSpanEvent{
+				Time:       otlpTime(uint64(e.TimeUnixNano)),
+				Name:       e.Name,
+				Attributes: e.Attributes,
+			})
+		}
+		return []otlpItem{{data: raw, value: span}}, err
+	}
+
+	return decodeOTLPMetricJSON(ctx, raw)
+}
+
+// otlpDataPointsJSON is the JSON encoding of the data of all metric types.
+type otlpDataPointsJSON struct {
+	DataPoints             []json.RawMessage `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+// decodeOTLPMetricJSON decodes a Metric object. It returns an item for each data point.
+func decodeOTLPMetricJSON(ctx otlpContext, raw json.RawMessage) ([]otlpItem, error) {
+	var m struct {
+		Name                 string              `json:"name"`
+		Description          string              `json:"description"`
+		Unit                 string              `json:"unit"`
+		Gauge                *otlpDataPointsJSON `json:"gauge"`
+		Sum                  *otlpDataPointsJSON `json:"sum"`
+		Histogram            *otlpDataPointsJSON `json:"histogram"`
+		ExponentialHistogram *otlpDataPointsJSON `json:"exponentialHistogram"`
+		Summary              *otlpDataPointsJSON `json:"summary"`
+	}
+	err := json.Unmarshal(raw, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	metric := OTLPDataPoint{
+		Resource:    ctx.resource,
+		Scope:       ctx.scope,
+		Metric:      m.Name,
+		Description: m.Description,
+		Unit:        m.Unit,
+	}
+	var data *otlpDataPointsJSON
+	for mt, d := range map[OTLPMetricType]*otlpDataPointsJSON{
+		OTLPGauge: m.Gauge, OTLPSum: m.Sum, OTLPHistogram: m.Histogram,
+		OTLPExponentialHistogram: m.ExponentialHistogram, OTLPSummary: m.Summary,
+	} {
+		if d != nil {
+			metric.Type = mt
+			data = d
+		}
+	}
+	if data == nil {
+		return nil, nil
+	}
+	metric.Temporality = data.AggregationTemporality
+	metric.Monotonic = data.IsMonotonic
+
+	var items []otlpItem
+	for _, p := range data.DataPoints {
+		var dp struct {
+			Attributes        otlpAttributes `json:"attributes"`
+			StartTimeUnixNano otlpUint       `json:"startTimeUnixNano"`
+			TimeUnixNano      otlpUint       `json:"timeUnixNano"`
+			AsDouble          *float64       `json:"asDouble"`
+			AsInt             *otlpInt       `json:"asInt"`
+			Count             otlpUint       `json:"count"`
+			Sum               float64        `json:"sum"`
+			BucketCounts      []otlpUint     `json:"bucketCounts"`
+			ExplicitBounds    []float64      `json:"explicitBounds"`
+		}
+		err = json.Unmarshal(p, &dp)
+		if err != nil {
+			return nil, err
+		}
+
+		point := metric
+		point.Attributes = dp.Attributes
+		point.Start = otlpTime(uint64(dp.StartTimeUnixNano))
+		point.Time = otlpTime(uint64(dp.TimeUnixNano))
+		switch {
+		case dp.AsDouble != nil:
+			point.Value = *dp.AsDouble
+		case dp.AsInt != nil:
+			point.Value = float64(*dp.AsInt)
+		}
+		if point.Type != OTLPGauge && point.Type != OTLPSum {
+			point.Count = uint64(dp.Count)
+			point.Sum = dp.Sum
+		}
+		if point.Type == OTLPHistogram {
+			for _, c := range dp.BucketCounts {
+				point.BucketCounts = append(point.BucketCounts, uint64(c))
+			}
+			point.ExplicitBounds = dp.ExplicitBounds
+		}
+		items = append(items, otlpItem{data: p, value: &point})
+	}
+	return items, nil
+}
+
+// otlpUint is an uint64 in JSON encoding: a number or a decimal string.
+type otlpUint uint64
+
+func (u *otlpUint) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseUint(string(bytes.Trim(data, `"`)), 10, 64)
+	*u = otlpUint(v)
+	return err
+}
+
+// otlpInt is an int64 in JSON encoding: a number or a decimal string.
+type otlpInt int64
+
+func (i *otlpInt) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseInt(string(bytes.Trim(data, `"`)), 10, 64)
+	*i = otlpInt(v)
+	return err
+}
+
+// otlpValue is an AnyValue in JSON encoding.
+type otlpValue struct {
+	value interface{}
+}
+
+func (v *otlpValue) UnmarshalJSON(data []byte) error {
+	var av struct {
+		StringValue *string  `json:"stringValue"`
+		BoolValue   *bool    `json:"boolValue"`
+		IntValue    *otlpInt `json:"intValue"`
+		DoubleValue *float64 `json:"doubleValue"`
+		ArrayValue  *struct {
+			Values []otlpValue `json:"values"`
+		} `json:"arrayValue"`
+		KvlistValue *struct {
+			Values otlpAttributes `json:"values"`
+		} `json:"kvlistValue"`
+		BytesValue []byte `json:"bytesValue"`
+	}
+	err := json.Unmarshal(data, &av)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case av.StringValue != nil:
+		v.value = *av.StringValue
+	case av.BoolValue != nil:
+		v.value = *av.BoolValue
+	case av.IntValue != nil:
+		v.value = int64(*av.IntValue)
+	case av.DoubleValue != nil:
+		v.value = *av.DoubleValue
+	case av.ArrayValue != nil:
+		values := []interface{}{}
+		for _, item := range av.ArrayValue.Values {
+			values = append(values, item.value)
+		}
+		v.value = values
+	case av.KvlistValue != nil:
+		kvs := map[string]interface{}(av.KvlistValue.Values)
+		if kvs == nil {
+			kvs = map[string]interface{}{}
+		}
+		v.value = kvs
+	case av.BytesValue != nil:
+		v.value = av.BytesValue
+	}
+	return nil
+}
+
+// otlpAttributes is a list of KeyValue in JSON encoding.
+type otlpAttributes map[string]interface{}
+
+func (a *otlpAttributes) UnmarshalJSON(data []byte) error {
+	var kvs []struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	err := json.Unmarshal(data, &kvs)
+	if err != nil {
+		return err
+	}
+	if len(kvs) == 0 {
+		return nil
+	}
+
+	*a = make(otlpAttributes, len(kvs))
+	for _, kv := range kvs {
+		(*a)[kv.Key] = kv.Value.value
+	}
+	return nil
+}
+
+// OTLPExportRecord is a record saved by an OTLPEndpoint for each export request.
+type OTLPExportRecord struct {
+	Record
+	// Export is the export request received.
+	Export *OTLPExport
+}
+
+// OTLPExportRecords returns the export requests received by OTLP endpoints in arrival order.
+func (ps *PayloadStorage) OTLPExportRecords() []OTLPExportRecord {
+	var r []OTLPExportRecord
+	for _, rec := range ps.Records() {
+		if export, ok := rec.Value.(*OTLPExport); ok {
+			r = append(r, OTLPExportRecord{Record: rec, Export: export})
+		}
+	}
+	return r
+}
+
+// OTLPLogRecord is a record saved by an OTLPEndpoint for each log record.
+type OTLPLogRecord struct {
+	Record
+	// Log is the log record received.
+	Log *OTLPLog
+}
+
+// OTLPLogRecords returns the log records received by OTLP endpoints in arrival order.
+func (ps *PayloadStorage) OTLPLogRecords() []OTLPLogRecord {
+	var r []OTLPLogRecord
+	for _, rec := range ps.Records() {
+		if lr, ok := rec.Value.(*OTLPLog); ok {
+			r = append(r, OTLPLogRecord{Record: rec, Log: lr})
+		}
+	}
+	return r
+}
+
+// OTLPSpanRecord is a record saved by an OTLPEndpoint for each span.
+type OTLPSpanRecord struct {
+	Record
+	// Span is the span received.
+	Span *OTLPSpan
+}
+
+// OTLPSpanRecords returns the spans received by OTLP endpoints in arrival order.
+func (ps *PayloadStorage) OTLPSpanRecords() []OTLPSpanRecord {
+	var r []OTLPSpanRecord
+	for _, rec := range ps.Records() {
+		if span, ok := rec.Value.(*OTLPSpan); ok {
+			r = append(r, OTLPSpanRecord{Record: rec, Span: span})
+		}
+	}
+	return r
+}
+
+// OTLPDataPointRecord is a record saved by an OTLPEndpoint for each metric data point.
+type OTLPDataPointRecord struct {
+	Record
+	// DataPoint is the data point received.
+	DataPoint *OTLPDataPoint
+}
+
+// OTLPDataPointRecords returns the metric data points received by OTLP endpoints in arrival order.
+func (ps *PayloadStorage) OTLPDataPointRecords() []OTLPDataPointRecord {
+	var r []OTLPDataPointRecord
+	for _, rec := range ps.Records() {
+		if point, ok := rec.Value.(*OTLPDataPoint); ok {
+			r = append(r, OTLPDataPointRecord{Record: rec, DataPoint: point})
+		}
+	}
+	return r
+}
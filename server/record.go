@@ -0,0 +1,78 @@
+package server
+
+import "time"
+
+// Record is a chunk of data received from a client. Each read operation (or each datagram in packet listeners)
+// saved in the storage produces one record.
+type Record struct {
+	// Seq is the sequence number of the record. It is assigned in arrival order across all clients, starting from 1.
+	Seq uint64
+	// Time is the moment when data was received.
+	Time time.Time
+	// RemoteAddr is the source address of the client. It is the same key used by GetPayload.
+	RemoteAddr string
+	// ConnID is the identifier of the connection where data was read from. It is 0 when the server is not
+	// connection oriented, like ListenerPacket.
+	ConnID uint64
+	// Data is the payload received.
+	Data []byte
+	// Value is the decoded representation of the data in listeners of structured protocols, like *RELPMessage in
+	// RELPListener or *HTTPRequest in HTTPListener. It is nil when data is saved as it is received.
+	Value interface{}
+}
+
+// Records returns the list of records received until now in arrival order.
+func (ps *PayloadStorage) Records() []Record {
+	ps.payloadsMtx.RLock()
+	defer ps.payloadsMtx.RUnlock()
+
+	r := make([]Record, len(ps.records))
+	copy(r, ps.records)
+	return r
+}
+
+// RecordsFor returns the list of records received from the client identified with its source address in
+// arrival order.
+func (ps *PayloadStorage) RecordsFor(remoteAddr string) []Record {
+	ps.payloadsMtx.RLock()
+	defer ps.payloadsMtx.RUnlock()
+
+	var r []Record
+	for _, rec := range ps.records {
+		if rec.RemoteAddr == remoteAddr {
+			r = append(r, rec)
+		}
+	}
+	return r
+}
+
+// RecordsSince returns the list of records which sequence number is greater than seq in arrival order.
+// RecordsSince(0) returns all records.
+func (ps *PayloadStorage) RecordsSince(seq uint64) []Record {
+	ps.payloadsMtx.RLock()
+	defer ps.payloadsMtx.RUnlock()
+
+	// records are sorted by Seq, so look for the first one after seq from the end.
+	i := len(ps.records)
+	for i > 0 && ps.records[i-1].Seq > seq {
+		i--
+	}
+
+	r := make([]Record, len(ps.records)-i)
+	copy(r, ps.records[i:])
+	return r
+}
+
+// NRecords returns the number of records received until now.
+func (ps *PayloadStorage) NRecords() int {
+	ps.payloadsMtx.RLock()
+	defer ps.payloadsMtx.RUnlock()
+	return len(ps.records)
+}
+
+// LastSeq returns the sequence number of the last record saved or 0 if no record was saved yet.
+func (ps *PayloadStorage) LastSeq() uint64 {
+	ps.payloadsMtx.RLock()
+	defer ps.payloadsMtx.RUnlock()
+	return ps.lastSeq
+}
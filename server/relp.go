@@ -0,0 +1,376 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ErrRELPDuplicateTxnr is the error recorded in a RELPMessage when its transaction number was already used by one
+// of the recent transactions of the connection.
+var ErrRELPDuplicateTxnr = errors.New("duplicate RELP transaction number")
+
+// ErrRELPOutOfOrderTxnr is the error recorded in a RELPMessage when its transaction number is not the next one of
+// the previous frame in the connection.
+var ErrRELPOutOfOrderTxnr = errors.New("out of order RELP transaction number")
+
+// RELPResponse is the response of a RELP listener to a syslog transaction.
+type RELPResponse int
+
+const (
+	// RELPAck saves the message and acknowledges the transaction with "200 OK".
+	RELPAck RELPResponse = iota
+	// RELPNack rejects the transaction with a "500" response. The message is saved too, so rejected transactions
+	// can be checked with RELPRecords.
+	RELPNack
+	// RELPWithhold saves the message but does not send any response for the transaction.
+	RELPWithhold
+)
+
+// String returns a text representation of the response.
+func (rr RELPResponse) String() string {
+	switch rr {
+	case RELPAck:
+		return "ack"
+	case RELPNack:
+		return "nack"
+	case RELPWithhold:
+		return "withhold"
+	}
+	return "unknown"
+}
+
+// RELPConfig is the configuration of the RELP (Reliable Event Logging Protocol) listeners.
+type RELPConfig struct {
+	// Response decides the response to each syslog command received. txnr is the transaction number in the
+	// connection described by ci and msg is the syslog message. All transactions are acknowledged if it is nil.
+	Response func(ci ConnectionInfo, txnr uint64, msg []byte) RELPResponse
+}
+
+// RELPMessage is the decoded representation of a syslog command received by a RELP listener. It is saved as
+// Record.Value.
+type RELPMessage struct {
+	// Txnr is the transaction number of the command.
+	Txnr uint64
+	// Response is the response sent to the client.
+	Response RELPResponse
+	// Err is ErrRELPDuplicateTxnr or ErrRELPOutOfOrderTxnr if the transaction number is not valid in the
+	// connection. The command is handled anyway.
+	Err error
+}
+
+// RELPRecord is a record received by a RELP listener.
+type RELPRecord struct {
+	Record
+	// Message is the RELP transaction of the record.
+	Message *RELPMessage
+}
+
+// RELPRecords returns the syslog messages received by RELP listeners in arrival order, including the rejected
+// ones. Records that are not saved by a RELP listener are ignored.
+func (ps *PayloadStorage) RELPRecords() []RELPRecord {
+	var r []RELPRecord
+	for _, rec := range ps.Records() {
+		if msg, ok := rec.Value.(*RELPMessage); ok {
+			r = append(r, RELPRecord{Record: rec, Message: msg})
+		}
+	}
+	return r
+}
+
+// RELPNackTransactions returns a RELPConfig.Response function that rejects the transactions with the numbers
+// txnrs, in any connection, and acknowledges the rest.
+func RELPNackTransactions(txnrs ...uint64) func(ConnectionInfo, uint64, []byte) RELPResponse {
+	return relpResponseFor(RELPNack, txnrs)
+}
+
+// RELPWithholdTransactions returns a RELPConfig.Response function that does not respond to the transactions with
+// the numbers txnrs, in any connection, and acknowledges the rest.
+func RELPWithholdTransactions(txnrs ...uint64) func(ConnectionInfo, uint64, []byte) RELPResponse {
+	return relpResponseFor(RELPWithhold, txnrs)
+}
+
+func relpResponseFor(response RELPResponse, txnrs []uint64) func(ConnectionInfo, uint64, []byte) RELPResponse {
+	return func(_ ConnectionInfo, txnr uint64, _ []byte) RELPResponse {
+		for _, t := range txnrs {
+			if t == txnr {
+				return response
+			}
+		}
+		return RELPAck
+	}
+}
+
+// RELPListener is a Listener that implements the server side of RELP: open, syslog and close commands are
+// replied with rsp command and each syslog message is saved as a record. Framer and Responder are not used.
+type RELPListener struct {
+	Listener
+	RELPConfig
+}
+
+// Start starts the server (listener) and enable the input data processing.
+func (rl *RELPListener) Start() error {
+	rl.protocol = &rl.RELPConfig
+	return rl.Listener.Start()
+}
+
+// StartContext starts the server like Start, and stops it when ctx is done.
+func (rl *RELPListener) StartContext(ctx context.Context) error {
+	err := rl.Start()
+	if err != nil {
+		return err
+	}
+
+	stopWhenDone(ctx, rl.stopped, rl.Stop)
+	return nil
+}
+
+// RELPTLSListener is like RELPListener but over TLS. TLS settings are the same as TLSListener.
+type RELPTLSListener struct {
+	TLSListener
+	RELPConfig
+}
+
+// Start starts the server (listener) and enable the input data processing.
+func (rtl *RELPTLSListener) Start() error {
+	rtl.protocol = &rtl.RELPConfig
+	return rtl.TLSListener.Start()
+}
+
+// StartContext starts the server like Start, and stops it when ctx is done.
+func (rtl *RELPTLSListener) StartContext(ctx context.Context) error {
+	err := rtl.Start()
+	if err != nil {
+		return err
+	}
+
+	stopWhenDone(ctx, rtl.stopped, rtl.Stop)
+	return nil
+}
+
+// relpFrame is a RELP frame: TXNR SP COMMAND SP DATALEN [SP DATA] TRAILER
+type relpFrame struct {
+	txnr    uint64
+	command string
+	data    []byte
+}
+
+const (
+	relpCmdOpen        = "open"
+	relpCmdSyslog      = "syslog"
+	relpCmdClose       = "close"
+	relpCmdRsp         = "rsp"
+	relpSoftware       = "saverserver"
+	relpMaxTxnrDigits  = 9
+	relpMaxTxnr        = 999999999
+	relpMaxCommandSize = 32
+	relpTxnrWindow     = 128
+)
+
+// relpSession tracks the transaction numbers used in a connection. They start at 1 and they are incremented by one
+// in each command, after relpMaxTxnr the next one is 1. Only the last relpTxnrWindow numbers are kept to detect
+// duplicates, so the memory used does not grow with the connection.
+type relpSession struct {
+	last   uint64
+	recent [relpTxnrWindow]uint64
+	size   int
+	pos    int
+}
+
+// check registers txnr as used and returns an error if it is duplicated or out of order.
+func (rs *relpSession) check(txnr uint64) error {
+	if rs.last == relpMaxTxnr && txnr == 1 {
+		rs.size, rs.pos = 0, 0
+	}
+
+	var err error
+	switch {
+	case rs.used(txnr):
+		err = fmt.Errorf("%w: %d", ErrRELPDuplicateTxnr, txnr)
+	case txnr != rs.last%relpMaxTxnr+1:
+		err = fmt.Errorf("%w: %d after %d", ErrRELPOutOfOrderTxnr, txnr, rs.last)
+	}
+
+	rs.recent[rs.pos] = txnr
+	rs.pos = (rs.pos + 1) % relpTxnrWindow
+	if rs.size < relpTxnrWindow {
+		rs.size++
+	}
+	rs.last = txnr
+	return err
+}
+
+// used returns true if txnr is one of the recent transaction numbers.
+func (rs *relpSession) used(txnr uint64) bool {
+	for i := 0; i < rs.size; i++ {
+		if rs.recent[i] == txnr {
+			return true
+		}
+	}
+	return false
+}
+
+// serveStream implements the streamProtocol interface.
+func (rc *RELPConfig) serveStream(scm *ConnectionMgr, ps *PayloadStorage, ce *connEntry) error {
+	maxSize := scm.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+
+	scanner := bufio.NewScanner(&connReader{scm: scm, ce: ce})
+	scanner.Buffer(make([]byte, readBufferSize), maxSize)
+	scanner.Split(splitRELPFrame)
+
+	opened := false
+	session := relpSession{}
+	for scanner.Scan() {
+		frame, err := parseRELPFrame(scanner.Bytes())
+		if err != nil {
+			return err
+		}
+		errTxnr := session.check(frame.txnr)
+
+		switch frame.command {
+		case relpCmdOpen:
+			opened = true
+			err = rc.respond(scm, ce, frame.txnr, fmt.Sprintf(
+				"200 OK\nrelp_version=0\nrelp_software=%s\ncommands=%s", relpSoftware, relpCmdSyslog,
+			))
+		case relpCmdSyslog:
+			if !opened {
+				err = rc.respond(scm, ce, frame.txnr, "500 session is not open")
+				break
+			}
+			err = rc.handleSyslog(scm, ps, ce, frame, errTxnr)
+		case relpCmdClose:
+			err = rc.respond(scm, ce, frame.txnr, "200 OK")
+			if err == nil {
+				return io.EOF
+			}
+		default:
+			err = rc.respond(scm, ce, frame.txnr, "500 command not supported: "+frame.command)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func (rc *RELPConfig) handleSyslog(
+	scm *ConnectionMgr, ps *PayloadStorage, ce *connEntry, frame relpFrame, errTxnr error,
+) error {
+	response := RELPAck
+	if rc.Response != nil {
+		response = rc.Response(scm.connectionInfo(ce), frame.txnr, frame.data)
+	}
+
+	scm.saveValue(ps, ce, frame.data, &RELPMessage{Txnr: frame.txnr, Response: response, Err: errTxnr})
+
+	switch response {
+	case RELPNack:
+		return rc.respond(scm, ce, frame.txnr, "500 message rejected")
+	case RELPWithhold:
+		return nil
+	default:
+		return rc.respond(scm, ce, frame.txnr, "200 OK")
+	}
+}
+
+func (rc *RELPConfig) respond(scm *ConnectionMgr, ce *connEntry, txnr uint64, data string) error {
+	return scm.writeResponse(ce, []byte(fmt.Sprintf("%d %s %d %s\n", txnr, relpCmdRsp, len(data), data)))
+}
+
+// splitRELPFrame is a bufio.SplitFunc that returns each RELP frame without the trailer.
+func splitRELPFrame(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	// Header is TXNR SP COMMAND SP DATALEN, DATALEN is followed by SP or by TRAILER if it is 0
+	fields := 0
+	headerLen := -1
+	for i, b := range data {
+		if b != ' ' && b != '\n' {
+			continue
+		}
+		fields++
+		if fields == 3 {
+			headerLen = i
+			break
+		}
+		if b == '\n' {
+			return 0, nil, fmt.Errorf("%w: incomplete RELP header %q", ErrInvalidFrame, data[0:i])
+		}
+	}
+	if headerLen < 0 {
+		if len(data) > relpMaxTxnrDigits+relpMaxCommandSize+relpMaxTxnrDigits+2 {
+			return 0, nil, fmt.Errorf("%w: RELP header too long", ErrInvalidFrame)
+		}
+		if atEOF {
+			return 0, nil, ErrTruncatedFrame
+		}
+		return 0, nil, nil
+	}
+
+	sp := bytes.LastIndexByte(data[0:headerLen], ' ')
+	dataLen, err := strconv.Atoi(string(data[sp+1 : headerLen]))
+	if err != nil || dataLen < 0 {
+		return 0, nil, fmt.Errorf("%w: invalid RELP data length %q", ErrInvalidFrame, data[sp+1:headerLen])
+	}
+	if dataLen > 0 && data[headerLen] != ' ' {
+		return 0, nil, fmt.Errorf("%w: RELP data expected after header", ErrInvalidFrame)
+	}
+
+	// Header, SP, data and trailer. SP is not present if there is no data
+	frameLen := headerLen + dataLen
+	if dataLen > 0 {
+		frameLen++
+	}
+	if len(data) <= frameLen {
+		if atEOF {
+			return 0, nil, ErrTruncatedFrame
+		}
+		return 0, nil, nil
+	}
+	if data[frameLen] != '\n' {
+		return 0, nil, fmt.Errorf("%w: RELP trailer not found", ErrInvalidFrame)
+	}
+
+	return frameLen + 1, data[0:frameLen], nil
+}
+
+// parseRELPFrame parses a frame returned by splitRELPFrame.
+func parseRELPFrame(token []byte) (relpFrame, error) {
+	parts := bytes.SplitN(token, []byte{' '}, 4)
+	if len(parts) < 3 {
+		return relpFrame{}, fmt.Errorf("%w: invalid RELP frame %q", ErrInvalidFrame, token)
+	}
+
+	if len(parts[0]) > relpMaxTxnrDigits {
+		return relpFrame{}, fmt.Errorf("%w: invalid RELP transaction number %q", ErrInvalidFrame, parts[0])
+	}
+	txnr, err := strconv.ParseUint(string(parts[0]), 10, 64)
+	if err != nil {
+		return relpFrame{}, fmt.Errorf("%w: invalid RELP transaction number %q", ErrInvalidFrame, parts[0])
+	}
+
+	if len(parts[1]) == 0 || len(parts[1]) > relpMaxCommandSize {
+		return relpFrame{}, fmt.Errorf("%w: invalid RELP command %q", ErrInvalidFrame, parts[1])
+	}
+
+	frame := relpFrame{txnr: txnr, command: string(parts[1])}
+	if len(parts) == 4 {
+		frame.data = parts[3]
+	}
+	return frame, nil
+}
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cyberluisda/saverserver-go/server/internal/protowire"
+	"github.com/cyberluisda/saverserver-go/server/internal/snappy"
+)
+
+// RemoteWritePath is the path of the Prometheus remote write endpoint.
+const RemoteWritePath = "/api/v1/write"
+
+// RemoteWriteRequest is a Prometheus remote write request received by a RemoteWriteEndpoint. It is saved as the
+// Value of the records, and the request body is saved as the record Data. The series of the request are saved in
+// the records that follow it.
+type RemoteWriteRequest struct {
+	// Status is the HTTP status code of the response.
+	Status int
+	// Series is the number of series in the request.
+	Series int
+	// Samples is the number of samples in the request.
+	Samples int
+	// Err is the error found while decode the request, if any.
+	Err error
+}
+
+// RemoteWriteSample is a sample of a series.
+type RemoteWriteSample struct {
+	// Value is the sample value.
+	Value float64
+	// Timestamp is the sample time in milliseconds since epoch.
+	Timestamp int64
+}
+
+// RemoteWriteSeries is a series received by a RemoteWriteEndpoint. It is saved as the Value of the records, and
+// the encoded TimeSeries message is saved as the record Data.
+type RemoteWriteSeries struct {
+	// Labels are the series labels, including the metric name in "__name__".
+	Labels map[string]string
+	// Samples are the samples of the series.
+	Samples []RemoteWriteSample
+}
+
+// RemoteWriteEndpoint emulates a Prometheus remote write receiver: it accepts snappy compressed protobuf
+// WriteRequest messages (remote write 1.0). See RemoteWritePath.
+type RemoteWriteEndpoint struct {
+	// Fail returns the HTTP status code to respond to the request instead of success, or 0 to accept it. Failed
+	// requests are saved, but their series are not. No request fails if it is nil.
+	Fail func(req *RemoteWriteRequest) int
+	// RetryAfter is sent in the Retry-After header of 429 and 503 responses if it is defined.
+	RetryAfter time.Duration
+	// MaxSize is the max size of the decompressed requests. DefaultMaxMessageSize is used if it is not defined.
+	MaxSize int
+}
+
+// Routes returns the routes to use the endpoint in an HTTPListener.
+func (rwe *RemoteWriteEndpoint) Routes() []HTTPRoute {
+	return []HTTPRoute{
+		{Method: http.MethodPost, Path: RemoteWritePath, Endpoint: rwe},
+	}
+}
+
+// Handle implements the HTTPEndpoint interface.
+func (rwe *RemoteWriteEndpoint) Handle(req *HTTPRequest, save func(data []byte, value interface{})) HTTPResponse {
+	if enc := req.Header.Get("Content-Encoding"); enc != "" && !strings.EqualFold(enc, "snappy") {
+		return textResponse(http.StatusUnsupportedMediaType, "unsupported content encoding "+enc)
+	}
+
+	wr := &RemoteWriteRequest{}
+	var series []*RemoteWriteSeries
+	var raws [][]byte
+	maxSize := rwe.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	data, err := snappy.Decode(req.Body, maxSize)
+	if err == nil {
+		err = forEachProtoField(data, 1, func(raw []byte) error {
+			s, err := decodeRemoteWriteSeries(raw)
+			series = append(series, s)
+			raws = append(raws, raw)
+			wr.Samples += len(s.Samples)
+			return err
+		})
+	}
+	if err != nil {
+		wr.Status = http.StatusBadRequest
+		if errors.Is(err, snappy.ErrTooLarge) {
+			wr.Status = http.StatusRequestEntityTooLarge
+		}
+		wr.Err = fmt.Errorf("while decode remote write request: %w", err)
+		save(req.Body, wr)
+		return textResponse(wr.Status, wr.Err.Error())
+	}
+	wr.Series = len(series)
+
+	if rwe.Fail != nil {
+		wr.Status = rwe.Fail(wr)
+	}
+	if wr.Status != 0 && wr.Status != http.StatusNoContent && wr.Status != http.StatusOK {
+		save(req.Body, wr)
+		resp := textResponse(wr.Status, http.StatusText(wr.Status))
+		setRetryAfter(resp.Header, wr.Status, rwe.RetryAfter)
+		return resp
+	}
+	wr.Status = http.StatusNoContent
+
+	save(req.Body, wr)
+	for n, s := range series {
+		save(raws[n], s)
+	}
+	return HTTPResponse{Status: wr.Status}
+}
+
+// decodeRemoteWriteSeries decodes a TimeSeries message. Exemplars and native histograms are ignored.
+func decodeRemoteWriteSeries(data []byte) (*RemoteWriteSeries, error) {
+	s := &RemoteWriteSeries{Labels: map[string]string{}}
+	err := forEachProtoMessage(data, func(f protowire.Field) error {
+		switch f.Num {
+		case 1:
+			var name, value string
+			err := forEachProtoMessage(f.Bytes, func(f protowire.Field) error {
+				switch f.Num {
+				case 1:
+					name = string(f.Bytes)
+				case 2:
+					value = string(f.Bytes)
+				}
+				return nil
+			})
+			s.Labels[name] = value
+			return err
+		case 2:
+			var sample RemoteWriteSample
+			err := forEachProtoMessage(f.Bytes, func(f protowire.Field) error {
+				switch f.Num {
+				case 1:
+					sample.Value = f.Double()
+				case 2:
+					sample.Timestamp = f.Int64()
+				}
+				return nil
+			})
+			s.Samples = append(s.Samples, sample)
+			return err
+		}
+		return nil
+	})
+	return s, err
+}
+
+func textResponse(status int, text string) HTTPResponse {
+	return HTTPResponse{
+		Status: status,
+		Header: http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}},
+		Body:   []byte(text + "\n"),
+	}
+}
+
+// MatchType is the type of a LabelMatcher.
+type MatchType int
+
+const (
+	// MatchEqual matches labels equal to the value (=).
+	MatchEqual MatchType = iota
+	// MatchNotEqual matches labels not equal to the value (!=).
+	MatchNotEqual
+	// MatchRegexp matches labels that match the regular expression in the value (=~).
+	MatchRegexp
+	// MatchNotRegexp matches labels that do not match the regular expression in the value (!~).
+	MatchNotRegexp
+)
+
+// LabelMatcher selects series by the value of a label, like the PromQL label matchers. Missing labels have the
+// empty value, and regular expressions are fully anchored.
+type LabelMatcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+}
+
+// matcher returns the function that checks a label value.
+func (lm LabelMatcher) matcher() (func(string) bool, error) {
+	switch lm.Type {
+	case MatchEqual:
+		return func(v string) bool { return v == lm.Value }, nil
+	case MatchNotEqual:
+		return func(v string) bool { return v != lm.Value }, nil
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + lm.Value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("while compile matcher of label %s: %w", lm.Name, err)
+		}
+		negate := lm.Type == MatchNotRegexp
+		return func(v string) bool { return re.MatchString(v) != negate }, nil
+	}
+	return nil, fmt.Errorf("unknown match type %d of label %s", lm.Type, lm.Name)
+}
+
+// RemoteWriteRequestRecord is a record saved by a RemoteWriteEndpoint for each request.
+type RemoteWriteRequestRecord struct {
+	Record
+	// Request is the request received.
+	Request *RemoteWriteRequest
+}
+
+// RemoteWriteRequestRecords returns the requests received by remote write endpoints in arrival order.
+func (ps *PayloadStorage) RemoteWriteRequestRecords() []RemoteWriteRequestRecord {
+	var r []RemoteWriteRequestRecord
+	for _, rec := range ps.Records() {
+		if wr, ok := rec.Value.(*RemoteWriteRequest); ok {
+			r = append(r, RemoteWriteRequestRecord{Record: rec, Request: wr})
+		}
+	}
+	return r
+}
+
+// RemoteWriteSeriesRecord is a record saved by a RemoteWriteEndpoint for each series of a request.
+type RemoteWriteSeriesRecord struct {
+	Record
+	// Series is the series received.
+	Series *RemoteWriteSeries
+}
+
+// RemoteWriteSeriesRecords returns the series received by remote write endpoints in arrival order. The same series
+// is returned once per request where it was received, see Series to get them merged.
+func (ps *PayloadStorage) RemoteWriteSeriesRecords() []RemoteWriteSeriesRecord {
+	var r []RemoteWriteSeriesRecord
+	for _, rec := range ps.Records() {
+		if s, ok := rec.Value.(*RemoteWriteSeries); ok {
+			r = append(r, RemoteWriteSeriesRecord{Record: rec, Series: s})
+		}
+	}
+	return r
+}
+
+// Series returns the series received by remote write endpoints that match all the matchers, in order of first
+// arrival. The samples of the series with the same labels are merged in arrival order.
+func (ps *PayloadStorage) Series(matchers ...LabelMatcher) ([]RemoteWriteSeries, error) {
+	checks := make([]func(string) bool, 0, len(matchers))
+	for _, lm := range matchers {
+		check, err := lm.matcher()
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+
+	var r []RemoteWriteSeries
+	index := map[string]int{}
+	for _, sr := range ps.RemoteWriteSeriesRecords() {
+		s := sr.Series
+		matched := true
+		for n, check := range checks {
+			if !check(s.Labels[matchers[n].Name]) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		key := seriesKey(s.Labels)
+		if i, found := index[key]; found {
+			r[i].Samples = append(r[i].Samples, s.Samples...)
+			continue
+		}
+		index[key] = len(r)
+		r = append(r, RemoteWriteSeries{
+			Labels:  s.Labels,
+			Samples: append([]RemoteWriteSample{}, s.Samples...),
+		})
+	}
+	return r, nil
+}
+
+// seriesKey returns a string that identifies the label set.
+func seriesKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%q=%q,", name, labels[name])
+	}
+	return b.String()
+}
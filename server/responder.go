@@ -0,0 +1,163 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Responder generates the data written back to the clients. When a Responder is defined in a server, it is called
+// for each message received, after it is saved, and the data returned is written to the client. Nothing is written
+// if it returns an empty slice. Responder is called from the goroutines that read the connections, so it must be
+// safe for concurrent use.
+type Responder interface {
+	// Respond returns the reply to msg received in the connection described by ci. In packet listeners only
+	// RemoteAddr and Opened, the reception time, are defined in ci. msg must not be retained after the call.
+	Respond(ci ConnectionInfo, msg []byte) []byte
+}
+
+// Greeter is implemented by the responders that write data to the clients as soon as the connection is accepted.
+// It is not used in packet listeners.
+type Greeter interface {
+	// Greet returns the data written to the connection described by ci before read from it.
+	Greet(ci ConnectionInfo) []byte
+}
+
+// ResponderFunc is an adapter to use ordinary functions as Responder.
+type ResponderFunc func(ci ConnectionInfo, msg []byte) []byte
+
+// Respond calls f(ci, msg).
+func (f ResponderFunc) Respond(ci ConnectionInfo, msg []byte) []byte {
+	return f(ci, msg)
+}
+
+// EchoResponder writes back each message received.
+type EchoResponder struct{}
+
+// Respond implements the Responder interface.
+func (EchoResponder) Respond(_ ConnectionInfo, msg []byte) []byte {
+	return msg
+}
+
+// BannerResponder writes Banner when a connection is accepted. Messages are replied by Responder, if it is defined.
+type BannerResponder struct {
+	Banner    []byte
+	Responder Responder
+}
+
+// Greet implements the Greeter interface.
+func (br BannerResponder) Greet(_ ConnectionInfo) []byte {
+	return br.Banner
+}
+
+// Respond implements the Responder interface.
+func (br BannerResponder) Respond(ci ConnectionInfo, msg []byte) []byte {
+	if br.Responder == nil {
+		return nil
+	}
+	return br.Responder.Respond(ci, msg)
+}
+
+// ScriptedResponder replies the messages received in a connection with Replies in order: the first message with
+// the first reply, the second one with the second reply and so on. When all replies were sent, the next messages
+// are not replied, or the sequence starts again if Loop is true. Each connection, or source address in packet
+// listeners, has its own sequence.
+type ScriptedResponder struct {
+	Replies [][]byte
+	Loop    bool
+
+	mtx  sync.Mutex
+	next map[string]int
+}
+
+// Respond implements the Responder interface.
+func (sr *ScriptedResponder) Respond(ci ConnectionInfo, msg []byte) []byte {
+	sr.mtx.Lock()
+	defer sr.mtx.Unlock()
+
+	if len(sr.Replies) == 0 {
+		return nil
+	}
+	if sr.next == nil {
+		sr.next = make(map[string]int)
+	}
+
+	key := fmt.Sprintf("%d %s", ci.ID, ci.RemoteAddr)
+	i := sr.next[key]
+	if i >= len(sr.Replies) {
+		if !sr.Loop {
+			return nil
+		}
+		i = 0
+	}
+	sr.next[key] = i + 1
+
+	return sr.Replies[i]
+}
+
+// responseWriteTimeout is the max time to write a response to a client.
+const responseWriteTimeout = time.Second * 5
+
+// greet writes the greeting of the Responder, if it is a Greeter, to the connection.
+func (scm *ConnectionMgr) greet(ce *connEntry) error {
+	greeter, ok := scm.Responder.(Greeter)
+	if !ok {
+		return nil
+	}
+	return scm.writeResponse(ce, greeter.Greet(scm.connectionInfo(ce)))
+}
+
+// respond writes the reply of the Responder to msg, if any.
+func (scm *ConnectionMgr) respond(ce *connEntry, msg []byte) error {
+	if scm.Responder == nil {
+		return nil
+	}
+	return scm.writeResponse(ce, scm.Responder.Respond(scm.connectionInfo(ce), msg))
+}
+
+func (scm *ConnectionMgr) writeResponse(ce *connEntry, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	err := ce.conn.SetWriteDeadline(time.Now().Add(responseWriteTimeout))
+	if err != nil {
+		return fmt.Errorf("while set write deadline: %w", err)
+	}
+
+	n, err := ce.conn.Write(data)
+	scm.activeConnsMtx.Lock()
+	ce.info.BytesOut += int64(n)
+	scm.activeConnsMtx.Unlock()
+	if err != nil {
+		return fmt.Errorf("while write response: %w", err)
+	}
+	return nil
+}
+
+// connectionInfo returns a copy of the connection information.
+func (scm *ConnectionMgr) connectionInfo(ce *connEntry) ConnectionInfo {
+	scm.activeConnsMtx.Lock()
+	defer scm.activeConnsMtx.Unlock()
+	return ce.info
+}
+
+// respond writes the reply of the Responder to msg, if any, to the source address.
+func (lp *ListenerPacket) respond(remoteAddr net.Addr, received time.Time, msg []byte) {
+	if lp.Responder == nil {
+		return
+	}
+
+	ci := ConnectionInfo{RemoteAddr: remoteAddr.String(), Opened: received}
+	data := lp.Responder.Respond(ci, msg)
+	if len(data) == 0 {
+		return
+	}
+
+	_, err := lp.conn.WriteTo(data, remoteAddr)
+	if err != nil {
+		log.Println("while write response to packet connection:", err)
+	}
+}
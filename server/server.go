@@ -6,15 +6,20 @@ The payload items can be listed while server is running of after it is closed
 package server
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/cyberluisda/saverserver-go/server/certs"
 )
 
 type BasicServer interface {
@@ -50,6 +55,12 @@ type BasicServer interface {
 
 	// Connections return the number of active connections.
 	Connections() int
+
+	// StartContext starts the server like Start, and stops it when ctx is done.
+	StartContext(ctx context.Context) error
+
+	// Shutdown stops the server gracefully waiting for the pending data until ctx is done.
+	Shutdown(ctx context.Context) error
 }
 
 type Listener struct {
@@ -95,32 +106,8 @@ func (lst *Listener) Start() error {
 	}
 
 	// Start the server to accept connection
-	ensureStarted := make(chan bool)
-	go func() {
-		firstConn := true
-		for {
-			if lst.Connections() == lst.MaxConnections {
-				log.Printf("Max active connections limit (%d) reached with %d\n", lst.MaxConnections, lst.activeConns)
-			} else {
-				if firstConn {
-					ensureStarted <- true
-					firstConn = false
-				}
-
-				conn, err := lst.listener.Accept()
-				if err != nil {
-					log.Printf("Error while accept connection %v\n", err)
-					break
-				} else {
-					go lst.handleIncomingConnection(conn)
-				}
-			}
-		}
-	}()
-
-	<-ensureStarted
-	lst.isStarted = true
-	close(ensureStarted)
+	lst.setStarted()
+	go lst.acceptLoop(lst.handleIncomingConnection)
 
 	return nil
 }
@@ -128,46 +115,27 @@ func (lst *Listener) Start() error {
 const readBufferSize = 1024
 
 func (lst *Listener) handleIncomingConnection(conn net.Conn) {
-	defer func() {
-		lst.activeConnsMtx.Lock()
-		lst.activeConns--
-		lst.activeConnsMtx.Unlock()
-	}()
-
-	lst.activeConnsMtx.Lock()
-	lst.activeConns++
-	lst.activeConnsMtx.Unlock()
-
-	// store incoming data
-	remoteAddress := conn.RemoteAddr().String()
-	for {
-		buffer := make([]byte, readBufferSize)
-		n, err := conn.Read(buffer)
-		if err != nil && err != io.EOF {
-			log.Println("while close connection: %w", err)
-			break
-		}
-		if n != 0 {
-			lst.AddPayload(remoteAddress, buffer, n)
-		}
-		if err == io.EOF {
-			break
-		}
-	}
-
-	// close conn
-	err := conn.Close()
-	if err != nil {
-		log.Println("while close connection: %w", err)
+	ce, ok := lst.admitConn(conn)
+	if !ok {
+		return
 	}
+	lst.serveConn(&lst.PayloadStorage, ce, ce.info.RemoteAddr)
 }
 
 type ListenerPacket struct {
 	PayloadStorage
 	Address string
-
-	conn    net.PacketConn
-	started bool
+	// Responder generates the data written back to the source address of each datagram. Nothing is written if it
+	// is nil.
+	Responder Responder
+
+	conn       net.PacketConn
+	protocol   packetProtocol
+	started    bool
+	draining   bool
+	mtx        sync.Mutex
+	stopped    chan struct{}
+	readerDone chan struct{}
 }
 
 // DefaultListenAddressListenerPacket is the default listen address for ListenerPacket
@@ -198,26 +166,28 @@ func (lp *ListenerPacket) Start() error {
 	lp.Init()
 
 	// Start the server to accept connections
+	lp.mtx.Lock()
+	lp.started = true
+	lp.draining = false
+	lp.stopped = make(chan struct{})
+	lp.readerDone = make(chan struct{})
+	lp.mtx.Unlock()
+
 	go lp.handleIncomingPackets()
 
-	lp.started = true
 	return nil
 }
 
 // Stop stops the listener, no more connections will be allowed and data processing is stopped.
 func (lp *ListenerPacket) Stop() error {
-	defer func() {
-		lp.started = false
-	}()
-
-	if lp.started {
-		err := lp.conn.Close()
-		if err != nil {
-			return fmt.Errorf("while close packet connection: %w", err)
-		}
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultSopTimeout)
+	defer cancel()
+	return lp.Shutdown(ctx)
+}
 
-	return nil
+// addRecord saves a record received in the socket. Subscribers are not waited when the listener is stopped.
+func (lp *ListenerPacket) addRecord(addr string, connID uint64, data []byte, value interface{}) (Record, bool) {
+	return lp.addRecordUntil(lp.stopped, addr, connID, data, value)
 }
 
 // GetAddress returns the address where the server is listening.
@@ -241,6 +211,8 @@ func (lp *ListenerPacket) Port() int {
 
 // Accepting connections.
 func (lp *ListenerPacket) Accepting() bool {
+	lp.mtx.Lock()
+	defer lp.mtx.Unlock()
 	return lp.started
 }
 
@@ -251,16 +223,51 @@ func (lp *ListenerPacket) Connections() int {
 
 const packetsBufferSize = 1024
 
+// maxDatagramSize is the size of the read buffer in packet listeners of protocols with big datagrams, like GELF.
+const maxDatagramSize = 65535
+
+// packetProtocol is implemented by the packet listeners of protocols that decode the datagrams, like GELF. When it
+// is defined, it replaces the default handling of the datagrams.
+type packetProtocol interface {
+	// handlePacket processes a datagram received from addr. data is only valid during the call.
+	handlePacket(lp *ListenerPacket, addr net.Addr, data []byte)
+}
+
 func (lp *ListenerPacket) handleIncomingPackets() {
+	defer close(lp.readerDone)
+
 	buffer := make([]byte, packetsBufferSize)
-	err := error(nil)
-	for err == nil {
+	if lp.protocol != nil {
+		buffer = make([]byte, maxDatagramSize)
+	}
+	for {
 		n, remoteAddr, err := lp.conn.ReadFrom(buffer)
-		if err != nil {
-			log.Println("while read data from packet connection: %w", err)
+		if n > 0 && lp.protocol != nil {
+			lp.protocol.handlePacket(lp, remoteAddr, buffer[0:n])
 		} else if n > 0 {
 			addr := remoteAddr.String()
-			lp.AddPayload(addr, buffer, n)
+			lp.addRecord(addr, 0, buffer[0:n], nil)
+			lp.respond(remoteAddr, time.Now(), buffer[0:n])
+		}
+
+		lp.mtx.Lock()
+		draining := lp.draining
+		lp.mtx.Unlock()
+
+		if err != nil {
+			if !draining {
+				log.Println("while read data from packet connection:", err)
+			}
+			return
+		}
+
+		// Keep reading while datagrams are queued
+		if draining {
+			err = lp.conn.SetReadDeadline(time.Now().Add(packetDrainIdle))
+			if err != nil {
+				log.Println("while set read deadline on packet connection:", err)
+				return
+			}
 		}
 	}
 }
@@ -283,6 +290,46 @@ type TLSListener struct {
 	MaxVersion uint16
 	// KeyLogWriter is the value with same name described in https://pkg.go.dev/crypto/tls@go1.16.15#Config
 	KeyLogWriter io.Writer
+	// CertFile and KeyFile are the paths of the PEM encoded certificate and key. They are used instead of CertPem
+	// and KeyPem if CertFile is defined, and they are checked while the listener is running to rotate the
+	// certificate when they change. See RotateCertificate.
+	CertFile string
+	KeyFile  string
+	// CertReloadInterval is the interval to check the changes of CertFile and KeyFile. DefaultCertReloadInterval is
+	// used if it is not defined.
+	CertReloadInterval time.Duration
+	// ServerNames are the certificates served by server name (SNI). Names are case insensitive, and names like
+	// "*.example.com" match any subdomain of one level. The default certificate (CertPem and KeyPem, or CertFile and
+	// KeyFile) is served when the server name is not sent or it is unknown.
+	ServerNames map[string]TLSCertificate
+	// UnknownServerNameAlert is the TLS alert sent to reject the handshakes that request a server name that is not
+	// in ServerNames. The default certificate is served instead if it is 0. See ErrUnknownServerName.
+	UnknownServerNameAlert TLSAlert
+	// NextProtos is the value with same name described in https://pkg.go.dev/crypto/tls@go1.16.15#Config
+	NextProtos []string
+	// IdentityFunc returns the key used to save the payloads of each client from its identity. LegacyIdentity is
+	// used if it is not defined. See GetPayloadsByCertCN and GetPayloadsByFingerprint.
+	IdentityFunc IdentityFunc
+
+	tlsConfig *tls.Config
+	certs     certStore
+}
+
+// NewEphemeralTLSListener returns a TLSListener, not started yet, with certificates generated by certs.NewBundle:
+// the server certificate for localhost, 127.0.0.1 and ::1, and the root CA to verify the client certificates if
+// they are sent. The bundle returned contains the matching client configuration, see certs.Bundle.ClientTLSConfig.
+func NewEphemeralTLSListener() (*TLSListener, *certs.Bundle, error) {
+	bundle, err := certs.NewBundle()
+	if err != nil {
+		return nil, nil, fmt.Errorf("while generate certificates: %w", err)
+	}
+
+	return &TLSListener{
+		CertPem:    bundle.Server.CertPEM,
+		KeyPem:     bundle.Server.KeyPEM,
+		ClientCAs:  bundle.ClientCAs(),
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}, bundle, nil
 }
 
 // Start starts the server (listener) and enable the input data processing.
@@ -296,33 +343,14 @@ func (tll *TLSListener) Start() error {
 		return fmt.Errorf("while extracts protocol, address and port: %w", err)
 	}
 
-	cert, err := tls.X509KeyPair(tll.CertPem, tll.KeyPem)
+	config, err := tll.buildTLSConfig()
 	if err != nil {
-		return fmt.Errorf("while loads Key and Certificate: %w", err)
-	}
-
-	if tll.MinVersion == 0 {
-		tll.MinVersion = tls.VersionTLS12
-	}
-	config := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		ClientAuth:   tll.ClientAuth,
-		MinVersion:   tll.MinVersion,
-		MaxVersion:   tll.MaxVersion,
-		KeyLogWriter: tll.KeyLogWriter,
-	}
-
-	if len(tll.ClientCAs) > 0 {
-		config.ClientCAs = x509.NewCertPool()
-		for i, bs := range tll.ClientCAs {
-			ok := config.ClientCAs.AppendCertsFromPEM(bs)
-			if !ok {
-				return fmt.Errorf("ClientCA certificate number %d to authenticate user can not be loaded", i+1)
-			}
-		}
+		return err
 	}
 
-	tll.listener, err = tls.Listen(netType, addr, config)
+	// TLS layer is added on each connection accepted, so the raw connection is available to the server.
+	tll.tlsConfig = config
+	tll.listener, err = net.Listen(netType, addr)
 	if err != nil {
 		return fmt.Errorf("while starts listener: %w, '%s' '%s' '%+v'", err, netType, addr, config)
 	}
@@ -340,95 +368,100 @@ func (tll *TLSListener) Start() error {
 	}
 
 	// Start the server to accept connection
-	ensureStarted := make(chan bool)
-	go func() {
-		firstConn := true
-		for {
-			if tll.Connections() == tll.MaxConnections {
-				log.Printf("Max active connections limit (%d) reached with %d\n", tll.MaxConnections, tll.activeConns)
-			} else {
-				if firstConn {
-					ensureStarted <- true
-					firstConn = false
-				}
-
-				conn, err := tll.listener.Accept()
-
-				if err != nil {
-					log.Printf("Error while accept connection %v\n", err)
-					break
-				} else {
-					tlsConn := conn.(*tls.Conn)
-					go tll.handleIncomingTLSConnection(tlsConn)
-				}
-			}
-		}
-	}()
-
-	<-ensureStarted
-	tll.isStarted = true
-	close(ensureStarted)
+	tll.setStarted()
+	tll.watchCertFiles(tll.stopped)
+	go tll.acceptLoop(tll.handleIncomingTLSConnection)
 
 	return nil
 }
 
-func (tll *TLSListener) handleIncomingTLSConnection(conn *tls.Conn) {
-	defer func() {
-		tll.activeConnsMtx.Lock()
-		tll.activeConns--
-		tll.activeConnsMtx.Unlock()
-	}()
-
-	tll.activeConnsMtx.Lock()
-	tll.activeConns++
-	tll.activeConnsMtx.Unlock()
-
-	// store incoming data
-	clientID := conn.RemoteAddr().String()
+func (tll *TLSListener) handleIncomingTLSConnection(rawConn net.Conn) {
+	ce, ok := tll.admitConn(rawConn)
+	if !ok {
+		return
+	}
 
-	err := conn.Handshake()
+	clientID, err := tll.handshake(ce)
 	if err != nil {
 		log.Println("Error while make handshake:", err)
+		tll.closeConn(ce, CloseReasonError, err)
 		return
 	}
 
-	cs := conn.ConnectionState()
-	nCerts := len(cs.PeerCertificates)
-
-	if nCerts > 0 {
-		clientID = "@" + clientID
-		for i, c := range cs.PeerCertificates {
-			clientID = c.Subject.String() + clientID
-			if i < nCerts-1 {
-				clientID = "-" + clientID
-			}
-		}
+	tll.serveConn(&tll.PayloadStorage, ce, clientID)
+}
+
+// buildTLSConfig loads the certificate and returns the TLS configuration defined by the listener settings. The
+// certificate is got on each handshake, so it can be rotated.
+func (tll *TLSListener) buildTLSConfig() (*tls.Config, error) {
+	cert, err := tll.loadCertificate()
+	if err != nil {
+		return nil, err
+	}
+	tll.certs.set(cert)
+	names, err := tll.loadServerNames()
+	if err != nil {
+		return nil, err
 	}
+	tll.certs.setServerNames(names, tll.UnknownServerNameAlert)
 
-	for {
-		buffer := make([]byte, readBufferSize)
-		var n int
-		n, err = conn.Read(buffer)
-		if err != nil && err != io.EOF {
-			log.Println("while close connection:", err)
-			break
-		}
-		if n != 0 {
-			tll.AddPayload(clientID, buffer, n)
-		}
-		if err == io.EOF {
-			break
+	if tll.MinVersion == 0 {
+		tll.MinVersion = tls.VersionTLS12
+	}
+	config := &tls.Config{
+		GetConfigForClient: tll.certs.getConfigForClient,
+		GetCertificate:     tll.certs.getCertificate,
+		ClientAuth:         tll.ClientAuth,
+		MinVersion:         tll.MinVersion,
+		MaxVersion:         tll.MaxVersion,
+		KeyLogWriter:       tll.KeyLogWriter,
+		NextProtos:         tll.NextProtos,
+	}
+
+	if len(tll.ClientCAs) > 0 {
+		config.ClientCAs = x509.NewCertPool()
+		for i, bs := range tll.ClientCAs {
+			ok := config.ClientCAs.AppendCertsFromPEM(bs)
+			if !ok {
+				return nil, fmt.Errorf("ClientCA certificate number %d to authenticate user can not be loaded", i+1)
+			}
 		}
 	}
 
-	// close conn
-	err = conn.Close()
+	return config, nil
+}
+
+// handshake adds the TLS layer to the connection and makes the handshake. It returns the client identifier used to
+// save the payloads, see IdentityFunc.
+func (tll *TLSListener) handshake(ce *connEntry) (string, error) {
+	hc := &handshakeConn{Conn: ce.raw}
+	conn := tls.Server(hc, tll.tlsConfig)
+	tll.wrapConn(ce, conn)
+
+	start := time.Now()
+	err := conn.Handshake()
+	serverName, serial := tll.certs.takeHandshake(hc)
+	cs := conn.ConnectionState()
+	info := newTLSInfo(cs, time.Since(start), err)
+	info.ServerName, info.CertSerial = serverName, serial
+	tll.activeConnsMtx.Lock()
+	ce.info.TLS = info
+	tll.activeConnsMtx.Unlock()
 	if err != nil {
-		log.Println("while close connection:", err)
+		return "", err
 	}
-}
 
-const tickerWhileStopping = time.Millisecond * 100
+	id := newClientIdentity(ce.info.RemoteAddr, cs)
+	tll.activeConnsMtx.Lock()
+	ce.info.Identity = id
+	tll.activeConnsMtx.Unlock()
+
+	identityFunc := tll.IdentityFunc
+	if identityFunc == nil {
+		identityFunc = LegacyIdentity
+	}
+	return identityFunc(id), nil
+}
 
 // ConnectionMgr is the manager of connections in Listeners servers.
 // ListenerPacket and similar implements its own connection management
@@ -442,49 +475,68 @@ type ConnectionMgr struct {
 	// Max number of connections to accept,
 	MaxConnections int
 
+	// AdmissionPolicy is the behavior when MaxConnections are active and a new client connects. Default is
+	// AdmissionWait.
+	AdmissionPolicy AdmissionPolicy
+
+	// RejectBanner is the data written to the connections rejected with AdmissionReject policy.
+	RejectBanner []byte
+
 	// StopTimeout is the timeout to wait for read data during Stop operation
 	StopTimeout time.Duration
 
+	// Framer splits the data received in messages. Each message is passed to CallBack and saved as a record. Empty
+	// messages are discarded. If it is nil, each chunk of data read from the connection is a message.
+	Framer Framer
+
+	// Responder generates the data written back to the clients. Nothing is written if it is nil.
+	Responder Responder
+
+	// Faults is the plan of faults injected in the connections. No fault is injected if it is nil.
+	Faults *FaultPlan
+
+	// MaxMessageSize is the max size of a message split by Framer. DefaultMaxMessageSize is used if it is not
+	// defined. Connection is closed with an error if a bigger message is received.
+	MaxMessageSize int
+
 	activeConns    int
 	activeConnsMtx sync.Mutex
 	listener       net.Listener
 	isStarted      bool
+	stopping       bool
+	lastConnID     uint64
+	connLog        []*connEntry
+	connsChanged   notifier
+	stopped        chan struct{}
+	slots          chan struct{}
+	heldConns      int
+	rejectedConns  int
+	protocol       streamProtocol
+}
+
+// newConnID returns a new unique connection identifier. First one is 1.
+func (scm *ConnectionMgr) newConnID() uint64 {
+	return atomic.AddUint64(&scm.lastConnID, 1)
 }
 
+// Stop stops the listener, no more connections will be allowed and data processing is stopped. It is like Shutdown
+// waiting StopTimeout at most for the active connections.
 func (scm *ConnectionMgr) Stop() error {
-	defer func() {
-		scm.isStarted = false
-		scm.activeConns = 0
-	}()
-
-	connPending := make(chan bool)
-	defer close(connPending)
-	go func() {
-		for {
-			scm.activeConnsMtx.Lock()
-			if scm.activeConns <= 0 {
-				scm.activeConnsMtx.Unlock()
-				connPending <- true
-				break
-			}
-			scm.activeConnsMtx.Unlock()
-			time.Sleep(tickerWhileStopping)
-		}
-	}()
+	ctx, cancel := context.WithTimeout(context.Background(), scm.StopTimeout)
+	defer cancel()
 
-	select {
-	case <-connPending:
-	case <-time.After(scm.StopTimeout):
-		defer scm.listener.Close()
+	err := scm.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
 		return fmt.Errorf("stop timeout %v reached while wait for stopping", scm.StopTimeout)
 	}
+	return err
+}
 
-	err := scm.listener.Close()
-	if err != nil {
-		return fmt.Errorf("while close the listener: %w", err)
-	}
-
-	return nil
+// isStopping returns true if server is stopping or stopped.
+func (scm *ConnectionMgr) isStopping() bool {
+	scm.activeConnsMtx.Lock()
+	defer scm.activeConnsMtx.Unlock()
+	return scm.stopping
 }
 
 // GetAddress returns the address where the server is listening.
@@ -522,7 +574,12 @@ func (scm *ConnectionMgr) Connections() int {
 
 type PayloadStorage struct {
 	payloads    map[string][]byte
+	records     []Record
+	lastSeq     uint64
 	payloadsMtx sync.RWMutex
+	changed     notifier
+	subscribers []*subscriber
+	subsMtx     sync.Mutex
 
 	// CallBack is a function called in each time that new payload is arrived. The func
 	//	receive the address and the payload received and it should return true if payload
@@ -547,32 +604,68 @@ func (ps *PayloadStorage) NPayloadItems() int {
 	return len(ps.payloads)
 }
 
-// Reset cleans the list of payloads received until now.
+// Reset cleans the list of payloads received until now. Sequence numbers of the records are not reset.
 func (ps *PayloadStorage) Reset() {
 	ps.payloadsMtx.Lock()
 	defer ps.payloadsMtx.Unlock()
 	for k := range ps.payloads {
 		delete(ps.payloads, k)
 	}
+	ps.records = nil
+	ps.changed.notify()
 }
 
+// AddPayload saves the first n bytes of buffer as a new record received from addr.
 func (ps *PayloadStorage) AddPayload(addr string, buffer []byte, n int) {
+	ps.addRecord(addr, 0, buffer[0:n], nil)
+}
+
+// addRecord applies the CallBack and, if data must be saved, stores it as a new record and sends it to the
+// subscribers. value is the decoded representation of data, if any. It returns the record and true if it was saved.
+func (ps *PayloadStorage) addRecord(addr string, connID uint64, data []byte, value interface{}) (Record, bool) {
+	return ps.addRecordUntil(nil, addr, connID, data, value)
+}
+
+// addRecordUntil is like addRecord but it stops waiting for the subscribers when stopped is closed. The record
+// is delivered to them anyway.
+func (ps *PayloadStorage) addRecordUntil(
+	stopped <-chan struct{}, addr string, connID uint64, data []byte, value interface{},
+) (Record, bool) {
 	ps.payloadsMtx.Lock()
-	defer ps.payloadsMtx.Unlock()
 
 	// First apply callback and check if we have to save payload
-	save := ps.CallBack(addr, buffer[0:n])
-
-	if save {
-		v, ok := ps.payloads[addr]
-		if ok {
-			v = append(v, buffer[0:n]...)
-		} else {
-			v = make([]byte, n)
-			copy(v, buffer)
-		}
-		ps.payloads[addr] = v
+	save := ps.CallBack(addr, data)
+	if !save {
+		ps.payloadsMtx.Unlock()
+		return Record{}, false
+	}
+
+	ps.lastSeq++
+	r := Record{
+		Seq:        ps.lastSeq,
+		Time:       time.Now(),
+		RemoteAddr: addr,
+		ConnID:     connID,
+		Data:       make([]byte, len(data)),
+		Value:      value,
 	}
+	copy(r.Data, data)
+	ps.records = append(ps.records, r)
+
+	// payloads is the view by address used by GetPayload and GetPayloads
+	ps.payloads[addr] = append(ps.payloads[addr], data...)
+
+	// Records are queued in the subscribers before release payloadsMtx to keep the order, and the caller waits for
+	// the subscribers out of the locks, so the storage can be used meanwhile.
+	subs := ps.enqueue(r)
+	ps.payloadsMtx.Unlock()
+
+	ps.changed.notify()
+	for _, sub := range subs {
+		sub.wait(r.Seq, stopped)
+	}
+
+	return r, true
 }
 
 // GetPayloadAddresses returns the list of source address of the clients sent data.
@@ -601,7 +694,12 @@ func (ps *PayloadStorage) GetPayloads() map[string][]byte {
 	ps.payloadsMtx.RLock()
 	defer ps.payloadsMtx.RUnlock()
 
-	return ps.payloads
+	r := make(map[string][]byte, len(ps.payloads))
+	for k, v := range ps.payloads {
+		r[k] = v
+	}
+
+	return r
 }
 
 func splitAddress(a string) (protocol, address string, err error) {
@@ -0,0 +1,605 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// SMTPMessage is an e-mail message received by an SMTPListener. It is saved as the Value of the records, and the
+// raw RFC 5322 message is saved as the record Data.
+type SMTPMessage struct {
+	// Helo is the domain sent by the client in the HELO or EHLO command.
+	Helo string
+	// From is the reverse path of the MAIL command. It is empty for the null sender <>.
+	From string
+	// To are the forward paths of the RCPT commands accepted.
+	To []string
+	// User is the user authenticated with the AUTH command, if any.
+	User string
+	// TLS is true if the message was received after STARTTLS.
+	TLS bool
+	// Header is the header of the message. It is defined only if SMTPConfig.ParseMessage is true.
+	Header mail.Header
+	// Parts are the leaf MIME parts of the message, with their body decoded. A message that is not multipart has
+	// a part with the whole body. They are defined only if SMTPConfig.ParseMessage is true.
+	Parts []SMTPPart
+	// Err is the error found while parse the message, if any.
+	Err error
+}
+
+// SMTPPart is a MIME part of an SMTPMessage.
+type SMTPPart struct {
+	// Header is the header of the part.
+	Header textproto.MIMEHeader
+	// Body is the body of the part, decoded if it has base64 or quoted-printable Content-Transfer-Encoding.
+	Body []byte
+}
+
+// SMTPConfig is the configuration of the SMTP session of the SMTP listeners.
+type SMTPConfig struct {
+	// Hostname is the name of the server sent in the greeting and EHLO replies. "localhost" is used if it is not
+	// defined.
+	Hostname string
+	// Users are the user names and passwords accepted by the AUTH command. Any credentials are accepted if it is
+	// empty.
+	Users map[string]string
+	// RequireAuth rejects the MAIL commands of sessions that are not authenticated.
+	RequireAuth bool
+	// RequireTLS rejects the commands of sessions without STARTTLS, except EHLO, HELO, STARTTLS, NOOP, RSET and
+	// QUIT. It requires TLS is configured.
+	RequireTLS bool
+	// RejectRecipient returns true if the recipient address of a RCPT command must be rejected. No recipient is
+	// rejected if it is nil.
+	RejectRecipient func(ci ConnectionInfo, addr string) bool
+	// ParseMessage enables the parse of the header and the MIME parts of the messages received.
+	ParseMessage bool
+}
+
+// SMTPListener is a server that accepts e-mail messages with SMTP (RFC 5321) and the ESMTP extensions SIZE,
+// 8BITMIME, PIPELINING, STARTTLS and AUTH (PLAIN and LOGIN). Each message is saved as a record. STARTTLS is
+// available if CertPem and KeyPem, or CertFile and KeyFile, are defined, the rest of TLS fields are applied to it
+// too. MaxMessageSize is the max size of the messages. Framer and Responder are not used.
+type SMTPListener struct {
+	TLSListener
+	SMTPConfig
+}
+
+// Start starts the server (listener) and enable the input data processing.
+func (sl *SMTPListener) Start() error {
+	if sl.Address == "" {
+		sl.Address = DefaultListenAddressListener
+	}
+
+	netType, addr, err := splitAddress(sl.Address)
+	if err != nil {
+		return fmt.Errorf("while extracts protocol, address and port: %w", err)
+	}
+
+	sl.tlsConfig = nil
+	if sl.tlsEnabled() {
+		sl.tlsConfig, err = sl.buildTLSConfig()
+		if err != nil {
+			return err
+		}
+	}
+	if sl.RequireTLS && sl.tlsConfig == nil {
+		return fmt.Errorf("TLS is required but certificate is not defined")
+	}
+
+	sl.listener, err = net.Listen(netType, addr)
+	if err != nil {
+		return fmt.Errorf("while starts listener: %w, '%s' '%s'", err, netType, addr)
+	}
+
+	// Default values
+	if sl.Address == DefaultListenAddressListener {
+		sl.Address = fmt.Sprintf("tcp://localhost:%d", sl.Port())
+	}
+	sl.Init()
+	if sl.MaxConnections <= 0 {
+		sl.MaxConnections = DefaultMaxConnections
+	}
+	if sl.StopTimeout == 0 {
+		sl.StopTimeout = DefaultSopTimeout
+	}
+	sl.protocol = sl
+
+	// Start the server to accept connection
+	sl.setStarted()
+	sl.watchCertFiles(sl.stopped)
+	go sl.acceptLoop(sl.handleIncomingSMTPConnection)
+
+	return nil
+}
+
+// StartContext starts the server like Start, and stops it when ctx is done.
+func (sl *SMTPListener) StartContext(ctx context.Context) error {
+	err := sl.Start()
+	if err != nil {
+		return err
+	}
+
+	stopWhenDone(ctx, sl.stopped, sl.Stop)
+	return nil
+}
+
+func (sl *SMTPListener) handleIncomingSMTPConnection(conn net.Conn) {
+	ce, ok := sl.admitConn(conn)
+	if !ok {
+		return
+	}
+	sl.serveConn(&sl.PayloadStorage, ce, ce.info.RemoteAddr)
+}
+
+// smtpMaxLine is the max length of the command lines.
+const smtpMaxLine = 4096
+
+// serveStream implements the streamProtocol interface.
+func (sl *SMTPListener) serveStream(scm *ConnectionMgr, ps *PayloadStorage, ce *connEntry) error {
+	ss := &smtpSession{
+		sl: sl,
+		ce: ce,
+		r:  bufio.NewReader(&connReader{scm: scm, ce: ce}),
+	}
+
+	hostname := sl.Hostname
+	if hostname == "" {
+		hostname = "localhost"
+	}
+	err := ss.reply(fmt.Sprintf("220 %s ESMTP saverserver ready", hostname))
+	if err != nil {
+		return err
+	}
+
+	for {
+		line, tooLong, err := ss.readLine(smtpMaxLine)
+		if err != nil {
+			return err
+		}
+		if tooLong {
+			err = ss.reply("500 5.5.2 Line too long")
+		} else {
+			err = ss.command(hostname, strings.TrimRight(string(line), "\r\n"))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// smtpSession is the state of an SMTP connection.
+type smtpSession struct {
+	sl   *SMTPListener
+	ce   *connEntry
+	r    *bufio.Reader
+	helo string
+	user string
+	tls  bool
+	// msg is the message of the current mail transaction, nil if MAIL was not received
+	msg *SMTPMessage
+}
+
+// command executes a command line. It returns io.EOF after QUIT.
+func (ss *smtpSession) command(hostname, line string) error {
+	verb, arg := line, ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		verb, arg = line[0:i], strings.TrimSpace(line[i+1:])
+	}
+	verb = strings.ToUpper(verb)
+
+	switch verb {
+	case "EHLO", "HELO", "STARTTLS", "NOOP", "RSET", "QUIT":
+	default:
+		if ss.sl.RequireTLS && !ss.tls {
+			return ss.reply("530 5.7.0 Must issue a STARTTLS command first")
+		}
+	}
+
+	switch verb {
+	case "EHLO":
+		if arg == "" {
+			return ss.reply("501 5.5.4 Syntax: EHLO hostname")
+		}
+		ss.helo = arg
+		ss.msg = nil
+		maxSize := ss.sl.MaxMessageSize
+		if maxSize <= 0 {
+			maxSize = DefaultMaxMessageSize
+		}
+		lines := []string{
+			fmt.Sprintf("%s Hello %s", hostname, arg),
+			fmt.Sprintf("SIZE %d", maxSize),
+			"8BITMIME",
+			"PIPELINING",
+		}
+		if ss.sl.tlsConfig != nil && !ss.tls {
+			lines = append(lines, "STARTTLS")
+		}
+		if ss.user == "" {
+			lines = append(lines, "AUTH PLAIN LOGIN")
+		}
+		var b strings.Builder
+		for i, l := range lines {
+			sep := "-"
+			if i == len(lines)-1 {
+				sep = " "
+			}
+			b.WriteString("250" + sep + l + "\r\n")
+		}
+		return ss.sl.writeResponse(ss.ce, []byte(b.String()))
+	case "HELO":
+		if arg == "" {
+			return ss.reply("501 5.5.4 Syntax: HELO hostname")
+		}
+		ss.helo = arg
+		ss.msg = nil
+		return ss.reply("250 " + hostname)
+	case "STARTTLS":
+		return ss.startTLS()
+	case "AUTH":
+		return ss.auth(arg)
+	case "MAIL":
+		return ss.mail(arg)
+	case "RCPT":
+		return ss.rcpt(arg)
+	case "DATA":
+		return ss.data()
+	case "RSET":
+		ss.msg = nil
+		return ss.reply("250 2.0.0 Ok")
+	case "NOOP":
+		return ss.reply("250 2.0.0 Ok")
+	case "VRFY":
+		return ss.reply("252 2.5.2 Cannot VRFY user")
+	case "QUIT":
+		err := ss.reply("221 2.0.0 Bye")
+		if err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	return ss.reply("500 5.5.2 Command not recognized")
+}
+
+func (ss *smtpSession) startTLS() error {
+	if ss.sl.tlsConfig == nil || ss.tls {
+		return ss.reply("454 4.7.0 TLS not available")
+	}
+	err := ss.reply("220 2.0.0 Ready to start TLS")
+	if err != nil {
+		return err
+	}
+
+	clientID, err := ss.sl.handshake(ss.ce)
+	if err != nil {
+		return fmt.Errorf("while make STARTTLS handshake: %w", err)
+	}
+	ss.sl.activeConnsMtx.Lock()
+	ss.ce.info.ClientID = clientID
+	ss.sl.activeConnsMtx.Unlock()
+
+	// Session is reset and data buffered before TLS is discarded
+	ss.r = bufio.NewReader(&connReader{scm: &ss.sl.ConnectionMgr, ce: ss.ce})
+	ss.tls = true
+	ss.helo = ""
+	ss.msg = nil
+	return nil
+}
+
+func (ss *smtpSession) auth(arg string) error {
+	if ss.helo == "" {
+		return ss.reply("503 5.5.1 Send EHLO first")
+	}
+	if ss.user != "" {
+		return ss.reply("503 5.5.1 Already authenticated")
+	}
+	if ss.msg != nil {
+		return ss.reply("503 5.5.1 Mail transaction in progress")
+	}
+
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return ss.reply("501 5.5.4 Syntax: AUTH mechanism")
+	}
+
+	var user, password string
+	switch strings.ToUpper(fields[0]) {
+	case "PLAIN":
+		resp, ok, err := ss.authResponse(fields[1:], "")
+		if err != nil || !ok {
+			return err
+		}
+		// authzid NUL authcid NUL passwd
+		parts := strings.Split(resp, "\x00")
+		if len(parts) != 3 {
+			return ss.reply("501 5.5.2 Invalid PLAIN credentials")
+		}
+		user, password = parts[1], parts[2]
+	case "LOGIN":
+		resp, ok, err := ss.authResponse(fields[1:], "Username:")
+		if err != nil || !ok {
+			return err
+		}
+		user = resp
+		resp, ok, err = ss.authResponse(nil, "Password:")
+		if err != nil || !ok {
+			return err
+		}
+		password = resp
+	default:
+		return ss.reply("504 5.5.4 Unrecognized authentication type")
+	}
+
+	if len(ss.sl.Users) > 0 {
+		expected, found := ss.sl.Users[user]
+		if !found || expected != password {
+			return ss.reply("535 5.7.8 Authentication credentials invalid")
+		}
+	}
+	ss.user = user
+	return ss.reply("235 2.7.0 Authentication successful")
+}
+
+// authResponse returns the decoded initial response, if it is in fields, or the response to the challenge. It
+// returns false if the exchange was cancelled or invalid, and the error reply was already written.
+func (ss *smtpSession) authResponse(fields []string, challenge string) (string, bool, error) {
+	var encoded string
+	if len(fields) > 0 {
+		encoded = fields[0]
+	} else {
+		err := ss.reply("334 " + base64.StdEncoding.EncodeToString([]byte(challenge)))
+		if err != nil {
+			return "", false, err
+		}
+		line, tooLong, err := ss.readLine(smtpMaxLine)
+		if err != nil {
+			return "", false, err
+		}
+		if tooLong {
+			return "", false, ss.reply("500 5.5.2 Line too long")
+		}
+		encoded = strings.TrimSpace(string(line))
+	}
+
+	if encoded == "*" {
+		return "", false, ss.reply("501 5.7.0 Authentication cancelled")
+	}
+	if encoded == "=" {
+		return "", true, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false, ss.reply("501 5.5.2 Cannot decode response")
+	}
+	return string(decoded), true, nil
+}
+
+func (ss *smtpSession) mail(arg string) error {
+	if ss.helo == "" {
+		return ss.reply("503 5.5.1 Send EHLO first")
+	}
+	if ss.msg != nil {
+		return ss.reply("503 5.5.1 Sender already specified")
+	}
+	if ss.sl.RequireAuth && ss.user == "" {
+		return ss.reply("530 5.7.0 Authentication required")
+	}
+
+	from, ok := smtpPath(arg, "FROM:")
+	if !ok {
+		return ss.reply("501 5.5.4 Syntax: MAIL FROM:<address>")
+	}
+	ss.msg = &SMTPMessage{Helo: ss.helo, From: from, User: ss.user, TLS: ss.tls}
+	return ss.reply("250 2.1.0 Ok")
+}
+
+func (ss *smtpSession) rcpt(arg string) error {
+	if ss.msg == nil {
+		return ss.reply("503 5.5.1 Need MAIL command")
+	}
+
+	to, ok := smtpPath(arg, "TO:")
+	if !ok || to == "" {
+		return ss.reply("501 5.5.4 Syntax: RCPT TO:<address>")
+	}
+	if ss.sl.RejectRecipient != nil && ss.sl.RejectRecipient(ss.sl.connectionInfo(ss.ce), to) {
+		return ss.reply("550 5.1.1 Mailbox unavailable")
+	}
+	ss.msg.To = append(ss.msg.To, to)
+	return ss.reply("250 2.1.5 Ok")
+}
+
+func (ss *smtpSession) data() error {
+	if ss.msg == nil {
+		return ss.reply("503 5.5.1 Need MAIL command")
+	}
+	if len(ss.msg.To) == 0 {
+		return ss.reply("503 5.5.1 Need RCPT command")
+	}
+	err := ss.reply("354 End data with <CR><LF>.<CR><LF>")
+	if err != nil {
+		return err
+	}
+
+	maxSize := ss.sl.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+
+	var data []byte
+	tooLarge := false
+	for {
+		line, tooLong, err := ss.readLine(maxSize)
+		if err != nil {
+			return err
+		}
+		if !tooLong && (string(line) == ".\r\n" || string(line) == ".\n") {
+			break
+		}
+		// Dot stuffing
+		if len(line) > 0 && line[0] == '.' {
+			line = line[1:]
+		}
+		if tooLong || len(data)+len(line) > maxSize {
+			tooLarge = true
+			data = nil
+		}
+		if !tooLarge {
+			data = append(data, line...)
+		}
+	}
+
+	msg := ss.msg
+	ss.msg = nil
+	if tooLarge {
+		return ss.reply("552 5.3.4 Message size exceeds fixed limit")
+	}
+
+	if ss.sl.ParseMessage {
+		msg.Header, msg.Parts, msg.Err = parseSMTPMessage(data)
+	}
+	ss.sl.saveValue(&ss.sl.PayloadStorage, ss.ce, data, msg)
+	return ss.reply("250 2.0.0 Ok: queued")
+}
+
+// readLine reads a line, including the line terminator. Lines longer than limit are discarded and returned bool
+// is true.
+func (ss *smtpSession) readLine(limit int) ([]byte, bool, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := ss.r.ReadSlice('\n')
+		if !tooLong && len(line)+len(chunk) > limit {
+			tooLong = true
+			line = nil
+		}
+		if !tooLong {
+			line = append(line, chunk...)
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		return line, tooLong, nil
+	}
+}
+
+func (ss *smtpSession) reply(line string) error {
+	return ss.sl.writeResponse(ss.ce, []byte(line+"\r\n"))
+}
+
+// smtpPath returns the address in arguments like "FROM:<user@example.com> SIZE=100". Returned bool is false if
+// the syntax is not valid.
+func smtpPath(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[0:len(prefix)], prefix) {
+		return "", false
+	}
+	path := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(path, "<") {
+		return "", false
+	}
+	end := strings.IndexByte(path, '>')
+	if end < 0 {
+		return "", false
+	}
+	return path[1:end], true
+}
+
+// parseSMTPMessage returns the header and the leaf MIME parts of the message.
+func parseSMTPMessage(data []byte) (mail.Header, []SMTPPart, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("while parse message header: %w", err)
+	}
+
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		return msg.Header, nil, fmt.Errorf("while read message body: %w", err)
+	}
+
+	parts, err := smtpParts(textproto.MIMEHeader(msg.Header), body, 0)
+	if err != nil {
+		return msg.Header, parts, fmt.Errorf("while parse MIME parts: %w", err)
+	}
+	return msg.Header, parts, nil
+}
+
+// smtpMaxPartDepth is the max nesting level of multipart bodies.
+const smtpMaxPartDepth = 16
+
+// smtpParts returns the leaf parts of a MIME entity.
+func smtpParts(header textproto.MIMEHeader, body []byte, depth int) ([]SMTPPart, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || depth >= smtpMaxPartDepth {
+		decoded, err := decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body)
+		return []SMTPPart{{Header: header, Body: decoded}}, err
+	}
+
+	var parts []SMTPPart
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		// Parts are decoded with their Content-Transfer-Encoding as leaves
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return parts, err
+		}
+
+		partBody, err := io.ReadAll(p)
+		if err != nil {
+			return parts, err
+		}
+		nested, err := smtpParts(p.Header, partBody, depth+1)
+		parts = append(parts, nested...)
+		if err != nil {
+			return parts, err
+		}
+	}
+}
+
+// decodeTransferEncoding decodes body with the Content-Transfer-Encoding. Other encodings than base64 and
+// quoted-printable are returned as is.
+func decodeTransferEncoding(encoding string, body []byte) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		// Line breaks are ignored by the decoder
+		return io.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(body)))
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(bytes.NewReader(body)))
+	}
+	return body, nil
+}
+
+// SMTPRecord is a record saved by an SMTPListener.
+type SMTPRecord struct {
+	Record
+	// Message is the message received.
+	Message *SMTPMessage
+}
+
+// SMTPRecords returns the messages received by SMTP listeners in arrival order.
+func (ps *PayloadStorage) SMTPRecords() []SMTPRecord {
+	var r []SMTPRecord
+	for _, rec := range ps.Records() {
+		if msg, ok := rec.Value.(*SMTPMessage); ok {
+			r = append(r, SMTPRecord{Record: rec, Message: msg})
+		}
+	}
+	return r
+}
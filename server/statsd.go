@@ -0,0 +1,451 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// StatsDKind is the kind of a StatsD message.
+type StatsDKind int
+
+const (
+	// StatsDCounter is a counter metric (c).
+	StatsDCounter StatsDKind = iota + 1
+	// StatsDGauge is a gauge metric (g).
+	StatsDGauge
+	// StatsDTimer is a timer metric (ms).
+	StatsDTimer
+	// StatsDHistogram is a histogram metric (h).
+	StatsDHistogram
+	// StatsDDistribution is a DogStatsD distribution metric (d).
+	StatsDDistribution
+	// StatsDSet is a set metric (s).
+	StatsDSet
+	// StatsDEvent is a DogStatsD event (_e).
+	StatsDEvent
+	// StatsDServiceCheck is a DogStatsD service check (_sc).
+	StatsDServiceCheck
+)
+
+// String returns a text representation of the kind.
+func (sk StatsDKind) String() string {
+	switch sk {
+	case StatsDCounter:
+		return "counter"
+	case StatsDGauge:
+		return "gauge"
+	case StatsDTimer:
+		return "timer"
+	case StatsDHistogram:
+		return "histogram"
+	case StatsDDistribution:
+		return "distribution"
+	case StatsDSet:
+		return "set"
+	case StatsDEvent:
+		return "event"
+	case StatsDServiceCheck:
+		return "service check"
+	}
+	return "unknown"
+}
+
+// StatsDMetric is a StatsD or DogStatsD message received by a StatsD listener: a metric, an event or a service
+// check. It is saved as the Value of the records, and the line of the message is saved as the record Data.
+type StatsDMetric struct {
+	// Kind is the kind of message.
+	Kind StatsDKind
+	// Name is the metric name, the event title or the service check name.
+	Name string
+	// Values are the values of numeric metrics. DogStatsD allows several values in the same message.
+	Values []float64
+	// Delta is true if the gauge value has sign, so it must be added to the current value.
+	Delta bool
+	// Member is the value of set metrics.
+	Member string
+	// SampleRate is the sample rate of the metric. It is 1 if it is not defined.
+	SampleRate float64
+	// Tags are the DogStatsD tags, like "env:prod".
+	Tags []string
+	// Text is the event text or the service check message.
+	Text string
+	// Status is the service check status: 0 OK, 1 warning, 2 critical and 3 unknown.
+	Status int
+	// Timestamp is the timestamp of events and service checks, if it is defined.
+	Timestamp int64
+	// Hostname is the hostname of events and service checks, if it is defined.
+	Hostname string
+	// Priority is the priority of events, if it is defined.
+	Priority string
+	// AlertType is the alert type of events, if it is defined.
+	AlertType string
+	// Err is the error found while parse the message, if any.
+	Err error
+}
+
+// StatsDListenerPacket is a ListenerPacket that parses StatsD and DogStatsD messages, one per line. Each message
+// is saved as a record, see StatsD for the aggregated view of the metrics. Responder is not used.
+type StatsDListenerPacket struct {
+	ListenerPacket
+}
+
+// Start starts the server (listener) and enable the input data processing.
+func (sl *StatsDListenerPacket) Start() error {
+	sl.protocol = statsDPacketProtocol{}
+	return sl.ListenerPacket.Start()
+}
+
+// StartContext starts the server like Start, and stops it when ctx is done.
+func (sl *StatsDListenerPacket) StartContext(ctx context.Context) error {
+	err := sl.Start()
+	if err != nil {
+		return err
+	}
+
+	stopWhenDone(ctx, sl.stopped, sl.Stop)
+	return nil
+}
+
+type statsDPacketProtocol struct{}
+
+// handlePacket implements the packetProtocol interface.
+func (statsDPacketProtocol) handlePacket(lp *ListenerPacket, addr net.Addr, data []byte) {
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		line = bytes.TrimSuffix(line, []byte{'\r'})
+		if len(line) > 0 {
+			lp.addRecord(addr.String(), 0, line, ParseStatsD(line))
+		}
+	}
+}
+
+// StatsDListener is a Listener that parses StatsD and DogStatsD messages delimited by new lines. Each message is
+// saved as a record, see StatsD for the aggregated view of the metrics. Framer and Responder are not used.
+type StatsDListener struct {
+	Listener
+}
+
+// Start starts the server (listener) and enable the input data processing.
+func (sl *StatsDListener) Start() error {
+	sl.protocol = statsDStreamProtocol{}
+	return sl.Listener.Start()
+}
+
+// StartContext starts the server like Start, and stops it when ctx is done.
+func (sl *StatsDListener) StartContext(ctx context.Context) error {
+	err := sl.Start()
+	if err != nil {
+		return err
+	}
+
+	stopWhenDone(ctx, sl.stopped, sl.Stop)
+	return nil
+}
+
+type statsDStreamProtocol struct{}
+
+// serveStream implements the streamProtocol interface.
+func (statsDStreamProtocol) serveStream(scm *ConnectionMgr, ps *PayloadStorage, ce *connEntry) error {
+	maxSize := scm.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+
+	scanner := bufio.NewScanner(&connReader{scm: scm, ce: ce})
+	scanner.Buffer(make([]byte, readBufferSize), maxSize)
+	scanner.Split(LineFramer{}.Split)
+	for scanner.Scan() {
+		if line := scanner.Bytes(); len(line) > 0 {
+			scm.saveValue(ps, ce, line, ParseStatsD(line))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// ErrInvalidStatsD is the error of the messages that are not valid StatsD or DogStatsD messages.
+var ErrInvalidStatsD = errors.New("invalid statsd message")
+
+// ParseStatsD parses a StatsD or DogStatsD message. Invalid messages are returned with Err defined.
+func ParseStatsD(line []byte) *StatsDMetric {
+	s := string(line)
+	m := &StatsDMetric{SampleRate: 1}
+
+	switch {
+	case strings.HasPrefix(s, "_e{"):
+		m.Kind = StatsDEvent
+		m.Err = m.parseEvent(s)
+	case strings.HasPrefix(s, "_sc|"):
+		m.Kind = StatsDServiceCheck
+		m.Err = m.parseServiceCheck(s)
+	default:
+		m.Err = m.parseMetric(s)
+	}
+	return m
+}
+
+// parseMetric parses name:value[:value...]|type[|@rate][|#tags][|other extensions]
+func (m *StatsDMetric) parseMetric(s string) error {
+	fields := strings.Split(s, "|")
+	if len(fields) < 2 {
+		return fmt.Errorf("%w: type not found in %q", ErrInvalidStatsD, s)
+	}
+
+	sep := strings.Index(fields[0], ":")
+	if sep <= 0 {
+		return fmt.Errorf("%w: value not found in %q", ErrInvalidStatsD, s)
+	}
+	m.Name = fields[0][0:sep]
+	values := fields[0][sep+1:]
+
+	switch fields[1] {
+	case "c":
+		m.Kind = StatsDCounter
+	case "g":
+		m.Kind = StatsDGauge
+	case "ms":
+		m.Kind = StatsDTimer
+	case "h":
+		m.Kind = StatsDHistogram
+	case "d":
+		m.Kind = StatsDDistribution
+	case "s":
+		m.Kind = StatsDSet
+	default:
+		return fmt.Errorf("%w: unknown metric type %q", ErrInvalidStatsD, fields[1])
+	}
+
+	if m.Kind == StatsDSet {
+		m.Member = values
+	} else {
+		if m.Kind == StatsDGauge && (strings.HasPrefix(values, "+") || strings.HasPrefix(values, "-")) {
+			m.Delta = true
+		}
+		for _, v := range strings.Split(values, ":") {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("%w: invalid value %q", ErrInvalidStatsD, v)
+			}
+			m.Values = append(m.Values, f)
+		}
+	}
+
+	for _, f := range fields[2:] {
+		switch {
+		case strings.HasPrefix(f, "@"):
+			rate, err := strconv.ParseFloat(f[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return fmt.Errorf("%w: invalid sample rate %q", ErrInvalidStatsD, f)
+			}
+			m.SampleRate = rate
+		case strings.HasPrefix(f, "#"):
+			m.Tags = parseStatsDTags(f)
+		}
+	}
+	return nil
+}
+
+// parseEvent parses _e{title.length,text.length}:title|text|d:timestamp|h:hostname|p:priority|t:alert_type|#tags
+func (m *StatsDMetric) parseEvent(s string) error {
+	end := strings.Index(s, "}:")
+	if end < 0 {
+		return fmt.Errorf("%w: invalid event header in %q", ErrInvalidStatsD, s)
+	}
+	lengths := strings.Split(s[3:end], ",")
+	if len(lengths) != 2 {
+		return fmt.Errorf("%w: invalid event header in %q", ErrInvalidStatsD, s)
+	}
+	titleLen, err1 := strconv.Atoi(lengths[0])
+	textLen, err2 := strconv.Atoi(lengths[1])
+	body := s[end+2:]
+	if err1 != nil || err2 != nil || titleLen < 0 || textLen < 0 || len(body) < titleLen+1+textLen ||
+		body[titleLen] != '|' {
+		return fmt.Errorf("%w: invalid event lengths in %q", ErrInvalidStatsD, s)
+	}
+
+	m.Name = body[0:titleLen]
+	m.Text = strings.ReplaceAll(body[titleLen+1:titleLen+1+textLen], "\\n", "\n")
+
+	return m.parseExtensions(body[titleLen+1+textLen:])
+}
+
+// parseServiceCheck parses _sc|name|status|d:timestamp|h:hostname|#tags|m:message
+func (m *StatsDMetric) parseServiceCheck(s string) error {
+	fields := strings.SplitN(s, "|", 4)
+	if len(fields) < 3 || fields[1] == "" {
+		return fmt.Errorf("%w: invalid service check %q", ErrInvalidStatsD, s)
+	}
+	m.Name = fields[1]
+
+	status, err := strconv.Atoi(fields[2])
+	if err != nil || status < 0 || status > 3 {
+		return fmt.Errorf("%w: invalid service check status %q", ErrInvalidStatsD, fields[2])
+	}
+	m.Status = status
+
+	if len(fields) == 4 {
+		return m.parseExtensions("|" + fields[3])
+	}
+	return nil
+}
+
+// parseExtensions parses the optional fields of events and service checks, with format |x:value or |#tags
+func (m *StatsDMetric) parseExtensions(s string) error {
+	if s == "" {
+		return nil
+	}
+	if !strings.HasPrefix(s, "|") {
+		return fmt.Errorf("%w: unexpected data %q", ErrInvalidStatsD, s)
+	}
+
+	for _, f := range strings.Split(s[1:], "|") {
+		switch {
+		case strings.HasPrefix(f, "#"):
+			m.Tags = parseStatsDTags(f)
+		case strings.HasPrefix(f, "d:"):
+			ts, err := strconv.ParseInt(f[2:], 10, 64)
+			if err != nil {
+				return fmt.Errorf("%w: invalid timestamp %q", ErrInvalidStatsD, f)
+			}
+			m.Timestamp = ts
+		case strings.HasPrefix(f, "h:"):
+			m.Hostname = f[2:]
+		case strings.HasPrefix(f, "p:"):
+			m.Priority = f[2:]
+		case strings.HasPrefix(f, "t:"):
+			m.AlertType = f[2:]
+		case strings.HasPrefix(f, "m:"):
+			m.Text = f[2:]
+		}
+	}
+	return nil
+}
+
+func parseStatsDTags(f string) []string {
+	return strings.Split(f[1:], ",")
+}
+
+// hasTags returns true if the metric has all the tags.
+func (m *StatsDMetric) hasTags(tags []string) bool {
+	for _, t := range tags {
+		found := false
+		for _, mt := range m.Tags {
+			if mt == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// StatsDRecord is a record saved by a StatsD listener.
+type StatsDRecord struct {
+	Record
+	// Metric is the message received.
+	Metric *StatsDMetric
+}
+
+// StatsDRecords returns the messages received by StatsD listeners in arrival order, including the invalid ones.
+func (ps *PayloadStorage) StatsDRecords() []StatsDRecord {
+	var r []StatsDRecord
+	for _, rec := range ps.Records() {
+		if m, ok := rec.Value.(*StatsDMetric); ok {
+			r = append(r, StatsDRecord{Record: rec, Metric: m})
+		}
+	}
+	return r
+}
+
+// StatsDView is the aggregated view of the metrics received by StatsD listeners.
+type StatsDView struct {
+	ps *PayloadStorage
+}
+
+// StatsD returns the aggregated view of the metrics received until now by StatsD listeners.
+func (ps *PayloadStorage) StatsD() StatsDView {
+	return StatsDView{ps: ps}
+}
+
+// metrics returns the valid metrics of kinds with name and all tags.
+func (sv StatsDView) metrics(name string, tags []string, kinds ...StatsDKind) []*StatsDMetric {
+	var r []*StatsDMetric
+	for _, sr := range sv.ps.StatsDRecords() {
+		m := sr.Metric
+		if m.Err != nil || m.Name != name || !m.hasTags(tags) {
+			continue
+		}
+		for _, k := range kinds {
+			if m.Kind == k {
+				r = append(r, m)
+				break
+			}
+		}
+	}
+	return r
+}
+
+// Counter returns the value of the counter with name, the sum of all values received scaled by their sample
+// rates. Only the metrics that have all tags are aggregated.
+func (sv StatsDView) Counter(name string, tags ...string) float64 {
+	total := 0.0
+	for _, m := range sv.metrics(name, tags, StatsDCounter) {
+		for _, v := range m.Values {
+			total += v / m.SampleRate
+		}
+	}
+	return total
+}
+
+// Gauge returns the value of the gauge with name: the last value received, with the deltas applied. Only the
+// metrics that have all tags are aggregated. Returned bool is false if the gauge was not received.
+func (sv StatsDView) Gauge(name string, tags ...string) (float64, bool) {
+	metrics := sv.metrics(name, tags, StatsDGauge)
+	value := 0.0
+	for _, m := range metrics {
+		for _, v := range m.Values {
+			if m.Delta {
+				value += v
+			} else {
+				value = v
+			}
+		}
+	}
+	return value, len(metrics) > 0
+}
+
+// Timings returns the values received, in arrival order, of the timer, histogram or distribution with name.
+// Only the metrics that have all tags are returned.
+func (sv StatsDView) Timings(name string, tags ...string) []float64 {
+	var r []float64
+	for _, m := range sv.metrics(name, tags, StatsDTimer, StatsDHistogram, StatsDDistribution) {
+		r = append(r, m.Values...)
+	}
+	return r
+}
+
+// Set returns the unique members, in arrival order, of the set with name. Only the metrics that have all tags
+// are aggregated.
+func (sv StatsDView) Set(name string, tags ...string) []string {
+	var r []string
+	seen := map[string]bool{}
+	for _, m := range sv.metrics(name, tags, StatsDSet) {
+		if !seen[m.Member] {
+			seen[m.Member] = true
+			r = append(r, m.Member)
+		}
+	}
+	return r
+}
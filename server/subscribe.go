@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+// OverflowPolicy is the behavior of a subscription when its buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the reception of data until subscriber reads from the channel. Only the connection that
+	// saves the record is blocked, the storage can be queried and waited meanwhile. It is released when the server
+	// is stopped, and the pending records are sent to the channel anyway.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest record in the buffer to make room for the new one.
+	OverflowDropOldest
+	// OverflowDropNewest discards the new record.
+	OverflowDropNewest
+)
+
+// DefaultSubscriptionBufferSize is the buffer size used in subscriptions if it is not defined.
+const DefaultSubscriptionBufferSize = 64
+
+// SubscribeOptions are the options of a subscription to the records saved in a PayloadStorage.
+type SubscribeOptions struct {
+	// Filter selects the records sent to the subscriber. All records are sent if it is nil.
+	Filter func(Record) bool
+	// BufferSize is the size of the channel buffer. DefaultSubscriptionBufferSize is used if it is not defined.
+	BufferSize int
+	// Overflow is the policy applied when buffer is full.
+	Overflow OverflowPolicy
+}
+
+// subscriber is a subscription. Records are queued in order while the storage is locked, and they are sent to
+// the channel by the deliver goroutine, so a subscriber that does not read never blocks the storage.
+type subscriber struct {
+	opts     SubscribeOptions
+	ch       chan Record
+	done     chan struct{}
+	doneOnce sync.Once
+
+	mtx   sync.Mutex
+	queue []Record
+	// handled is the Seq of the last record sent to the channel, discarded by Filter or by the overflow policy.
+	handled uint64
+	changed notifier
+}
+
+// Subscribe returns a channel where each new record saved is sent, in arrival order, until ctx is done or the
+// returned cancel function is called. Then the channel is closed. Records received before the subscription are
+// not sent. Data of the records is shared with the storage and must not be modified.
+func (ps *PayloadStorage) Subscribe(ctx context.Context, opts SubscribeOptions) (<-chan Record, func()) {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultSubscriptionBufferSize
+	}
+
+	sub := &subscriber{
+		opts: opts,
+		ch:   make(chan Record, opts.BufferSize),
+		done: make(chan struct{}),
+	}
+
+	ps.subsMtx.Lock()
+	ps.subscribers = append(ps.subscribers, sub)
+	ps.subsMtx.Unlock()
+	go sub.deliver()
+
+	cancel := func() {
+		// Channel is closed by deliver
+		sub.doneOnce.Do(func() { close(sub.done) })
+
+		ps.subsMtx.Lock()
+		defer ps.subsMtx.Unlock()
+		for i, s := range ps.subscribers {
+			if s == sub {
+				ps.subscribers = append(ps.subscribers[0:i], ps.subscribers[i+1:]...)
+				break
+			}
+		}
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-sub.done:
+		}
+	}()
+
+	return sub.ch, cancel
+}
+
+// Subscribers returns the number of active subscriptions.
+func (ps *PayloadStorage) Subscribers() int {
+	ps.subsMtx.Lock()
+	defer ps.subsMtx.Unlock()
+	return len(ps.subscribers)
+}
+
+// enqueue queues the record in the subscribers and returns them. payloadsMtx must be locked to keep the order of
+// the records. It never blocks, see wait.
+func (ps *PayloadStorage) enqueue(r Record) []*subscriber {
+	ps.subsMtx.Lock()
+	defer ps.subsMtx.Unlock()
+
+	subs := make([]*subscriber, len(ps.subscribers))
+	copy(subs, ps.subscribers)
+	for _, sub := range subs {
+		sub.mtx.Lock()
+		sub.queue = append(sub.queue, r)
+		sub.mtx.Unlock()
+		sub.changed.notify()
+	}
+	return subs
+}
+
+// deliver sends the queued records to the channel until the subscription is cancelled. Then the channel is closed.
+func (sub *subscriber) deliver() {
+	defer close(sub.ch)
+	for {
+		changed := sub.changed.wait()
+		sub.mtx.Lock()
+		if len(sub.queue) == 0 {
+			sub.mtx.Unlock()
+			select {
+			case <-changed:
+				continue
+			case <-sub.done:
+				return
+			}
+		}
+		r := sub.queue[0]
+		sub.queue = sub.queue[1:]
+		sub.mtx.Unlock()
+
+		if sub.opts.Filter == nil || sub.opts.Filter(r) {
+			if !sub.send(r) {
+				return
+			}
+		}
+
+		sub.mtx.Lock()
+		sub.handled = r.Seq
+		sub.mtx.Unlock()
+		sub.changed.notify()
+	}
+}
+
+// send sends the record to the channel applying the overflow policy. It returns false if the subscription was
+// cancelled.
+func (sub *subscriber) send(r Record) bool {
+	switch sub.opts.Overflow {
+	case OverflowDropNewest:
+		select {
+		case sub.ch <- r:
+		default:
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case sub.ch <- r:
+				return true
+			default:
+			}
+			// Buffer is full, discard the oldest one and try again
+			select {
+			case <-sub.ch:
+			default:
+			}
+		}
+	default:
+		select {
+		case sub.ch <- r:
+		case <-sub.done:
+			return false
+		}
+	}
+	return true
+}
+
+// wait blocks until the record with seq is handled by the subscriber, the subscription is cancelled or stopped is
+// closed. No lock must be held by the caller.
+func (sub *subscriber) wait(seq uint64, stopped <-chan struct{}) {
+	for {
+		changed := sub.changed.wait()
+		sub.mtx.Lock()
+		handled := sub.handled
+		sub.mtx.Unlock()
+		if handled >= seq {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-sub.done:
+			return
+		case <-stopped:
+			return
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import "github.com/cyberluisda/saverserver-go/server/syslog"
+
+// SyslogRecord is a record parsed as a syslog message.
+type SyslogRecord struct {
+	Record
+	// Message is the syslog message parsed. It is nil if the record can not be parsed.
+	Message *syslog.Message
+	// Err is the parse error if the record is not a valid syslog message.
+	Err error
+}
+
+// SyslogRecords returns all records parsed as syslog messages in arrival order. Each record must contain a full
+// message, so a Framer should be used in stream listeners. Malformed messages are returned with Err defined.
+func (ps *PayloadStorage) SyslogRecords() []SyslogRecord {
+	return ps.syslogRecords(func(SyslogRecord) bool { return true })
+}
+
+// SyslogRecordsByTag returns the valid syslog messages which tag (app-name in RFC 5424) is tag.
+func (ps *PayloadStorage) SyslogRecordsByTag(tag string) []SyslogRecord {
+	return ps.syslogRecords(func(sr SyslogRecord) bool {
+		return sr.Err == nil && sr.Message.AppName == tag
+	})
+}
+
+// SyslogRecordsBySeverity returns the valid syslog messages with severity. See syslog.SeverityEmergency and
+// related constants.
+func (ps *PayloadStorage) SyslogRecordsBySeverity(severity int) []SyslogRecord {
+	return ps.syslogRecords(func(sr SyslogRecord) bool {
+		return sr.Err == nil && sr.Message.Severity == severity
+	})
+}
+
+// SyslogParseErrors returns the records that are not valid syslog messages.
+func (ps *PayloadStorage) SyslogParseErrors() []SyslogRecord {
+	return ps.syslogRecords(func(sr SyslogRecord) bool {
+		return sr.Err != nil
+	})
+}
+
+func (ps *PayloadStorage) syslogRecords(filter func(SyslogRecord) bool) []SyslogRecord {
+	var r []SyslogRecord
+	for _, rec := range ps.Records() {
+		sr := SyslogRecord{Record: rec}
+		sr.Message, sr.Err = syslog.Parse(rec.Data)
+		if filter(sr) {
+			r = append(r, sr)
+		}
+	}
+	return r
+}
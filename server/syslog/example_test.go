@@ -0,0 +1,56 @@
+package syslog_test
+
+import (
+	"fmt"
+
+	"github.com/cyberluisda/saverserver-go/server/syslog"
+)
+
+func ExampleParse() {
+	m, err := syslog.Parse([]byte(
+		`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 ` +
+			`[exampleSDID@32473 iut="3" eventSource="Application"] An application event log entry...`,
+	))
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(m.Format, m.Facility, m.Severity, m.Timestamp.UnixNano())
+	fmt.Println(m.Hostname, m.AppName, m.ProcID == "", m.MsgID)
+	fmt.Println(m.StructuredData["exampleSDID@32473"]["eventSource"])
+	fmt.Println(m.Message)
+
+	//Output:
+	// RFC5424 20 5 1065910455003000000
+	// mymachine.example.com evntslog true ID47
+	// Application
+	// An application event log entry...
+}
+
+func ExampleParse_rfc3164() {
+	m, err := syslog.Parse([]byte("<34>Oct 11 22:14:15 mymachine su[230]: 'su root' failed for lonvick on /dev/pts/8\n"))
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(m.Format, m.Facility, m.Severity, m.Timestamp.Format("Jan _2 15:04:05"))
+	fmt.Println(m.Hostname, m.Tag(), m.ProcID)
+	fmt.Println(m.Message)
+
+	//Output:
+	// RFC3164 4 2 Oct 11 22:14:15
+	// mymachine su 230
+	// 'su root' failed for lonvick on /dev/pts/8
+}
+
+func ExampleParse_error() {
+	_, err := syslog.Parse([]byte("this is not syslog"))
+	fmt.Println(err)
+
+	_, err = syslog.Parse([]byte(`<13>1 - - - - - [broken`))
+	fmt.Println(err)
+
+	//Output:
+	// invalid syslog message: priority not found
+	// invalid syslog message: malformed structured data element
+}
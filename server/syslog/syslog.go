@@ -0,0 +1,311 @@
+/*
+Package syslog parses syslog messages in RFC 3164 (BSD) and RFC 5424 formats.
+
+Messages must be already framed, see server.LineFramer and server.OctetCountingFramer to split syslog messages
+received over stream connections.
+*/
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is the syslog format of a message.
+type Format int
+
+const (
+	// FormatRFC3164 is the BSD syslog format.
+	FormatRFC3164 Format = iota
+	// FormatRFC5424 is the syslog protocol format.
+	FormatRFC5424
+)
+
+// String returns a text representation of the format.
+func (f Format) String() string {
+	if f == FormatRFC5424 {
+		return "RFC5424"
+	}
+	return "RFC3164"
+}
+
+// Severity levels defined in RFC 5424.
+const (
+	SeverityEmergency = iota
+	SeverityAlert
+	SeverityCritical
+	SeverityError
+	SeverityWarning
+	SeverityNotice
+	SeverityInformational
+	SeverityDebug
+)
+
+// NilValue is the value used in RFC 5424 for fields without value.
+const NilValue = "-"
+
+// Message is a parsed syslog message.
+type Message struct {
+	// Format is the format detected for the message.
+	Format Format
+	// Priority is the PRI value, Facility * 8 + Severity.
+	Priority int
+	// Facility is the facility code extracted from Priority.
+	Facility int
+	// Severity is the severity code extracted from Priority.
+	Severity int
+	// Version is the protocol version. Always 0 in RFC 3164 messages.
+	Version int
+	// Timestamp is the time of the message. Zero value if it is not present. RFC 3164 timestamps have not year,
+	// current year is used.
+	Timestamp time.Time
+	// Hostname is the host that sent the message. Empty if it is not present.
+	Hostname string
+	// AppName is the application name in RFC 5424 messages, or the tag in RFC 3164 messages.
+	AppName string
+	// ProcID is the process identifier. In RFC 3164 messages it is the value between square brackets after the tag.
+	ProcID string
+	// MsgID is the message type identifier. Only present in RFC 5424 messages.
+	MsgID string
+	// StructuredData is the list of structured data params by element ID. Only present in RFC 5424 messages.
+	StructuredData map[string]map[string]string
+	// Message is the free-form text of the message.
+	Message string
+}
+
+// Tag is an alias of AppName commonly used in RFC 3164 messages.
+func (m *Message) Tag() string {
+	return m.AppName
+}
+
+// ErrInvalidMessage is the error returned when a message can not be parsed.
+var ErrInvalidMessage = errors.New("invalid syslog message")
+
+const maxPriority = 191
+
+// Parse parses a syslog message, RFC 5424 or RFC 3164 format is detected automatically.
+func Parse(data []byte) (*Message, error) {
+	data = bytes.TrimRight(data, "\r\n\x00")
+
+	pri, rest, err := parsePriority(data)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Message{
+		Priority: pri,
+		Facility: pri / 8,
+		Severity: pri % 8,
+	}
+
+	// RFC 5424 messages have a version after priority: "<PRI>1 "
+	if i := bytes.IndexByte(rest, ' '); i > 0 && i <= 3 && isDigits(rest[0:i]) {
+		m.Format = FormatRFC5424
+		m.Version, _ = strconv.Atoi(string(rest[0:i]))
+		err = parseRFC5424(m, string(rest[i+1:]))
+	} else {
+		m.Format = FormatRFC3164
+		err = parseRFC3164(m, string(rest))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func parsePriority(data []byte) (int, []byte, error) {
+	if len(data) < 3 || data[0] != '<' {
+		return 0, nil, fmt.Errorf("%w: priority not found", ErrInvalidMessage)
+	}
+
+	end := bytes.IndexByte(data, '>')
+	if end < 2 || end > 4 || !isDigits(data[1:end]) {
+		return 0, nil, fmt.Errorf("%w: malformed priority", ErrInvalidMessage)
+	}
+
+	pri, _ := strconv.Atoi(string(data[1:end]))
+	if pri > maxPriority {
+		return 0, nil, fmt.Errorf("%w: priority %d out of range", ErrInvalidMessage, pri)
+	}
+
+	return pri, data[end+1:], nil
+}
+
+func parseRFC5424(m *Message, s string) error {
+	fields := make([]string, 5)
+	for i := range fields {
+		var ok bool
+		fields[i], s, ok = nextField(s)
+		if !ok {
+			return fmt.Errorf("%w: RFC 5424 header is not complete", ErrInvalidMessage)
+		}
+	}
+
+	if fields[0] != NilValue {
+		ts, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return fmt.Errorf("%w: timestamp: %v", ErrInvalidMessage, err)
+		}
+		m.Timestamp = ts
+	}
+	m.Hostname = nilToEmpty(fields[1])
+	m.AppName = nilToEmpty(fields[2])
+	m.ProcID = nilToEmpty(fields[3])
+	m.MsgID = nilToEmpty(fields[4])
+
+	sd, rest, err := parseStructuredData(s)
+	if err != nil {
+		return err
+	}
+	m.StructuredData = sd
+	m.Message = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff")
+
+	return nil
+}
+
+func parseStructuredData(s string) (map[string]map[string]string, string, error) {
+	if strings.HasPrefix(s, NilValue) {
+		return nil, s[len(NilValue):], nil
+	}
+	if !strings.HasPrefix(s, "[") {
+		return nil, "", fmt.Errorf("%w: structured data not found", ErrInvalidMessage)
+	}
+
+	sd := make(map[string]map[string]string)
+	for strings.HasPrefix(s, "[") {
+		end := strings.IndexAny(s, " ]")
+		if end < 2 {
+			return nil, "", fmt.Errorf("%w: malformed structured data element", ErrInvalidMessage)
+		}
+		params := make(map[string]string)
+		sd[s[1:end]] = params
+		s = s[end:]
+
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+			eq := strings.Index(s, "=\"")
+			if eq < 1 {
+				return nil, "", fmt.Errorf("%w: malformed structured data param", ErrInvalidMessage)
+			}
+			name := s[0:eq]
+			value, n, err := parseParamValue(s[eq+2:])
+			if err != nil {
+				return nil, "", err
+			}
+			params[name] = value
+			s = s[eq+2+n:]
+		}
+
+		if !strings.HasPrefix(s, "]") {
+			return nil, "", fmt.Errorf("%w: structured data element is not closed", ErrInvalidMessage)
+		}
+		s = s[1:]
+	}
+
+	return sd, s, nil
+}
+
+// parseParamValue returns the unescaped value of a param and the number of bytes consumed, closing quote included.
+func parseParamValue(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\' || s[i+1] == ']') {
+				i++
+			}
+			b.WriteByte(s[i])
+		case '"':
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("%w: structured data param value is not closed", ErrInvalidMessage)
+}
+
+// rfc3164TimestampLayouts are the timestamp layouts accepted in RFC 3164 messages
+var rfc3164TimestampLayouts = []string{"Jan _2 15:04:05", "Jan 2 15:04:05"}
+
+func parseRFC3164(m *Message, s string) error {
+	// Timestamp is optional, and some senders use RFC 3339 format
+	for _, layout := range rfc3164TimestampLayouts {
+		if len(s) < len(layout) {
+			continue
+		}
+		ts, err := time.ParseInLocation(layout, s[0:len(layout)], time.Local)
+		if err == nil {
+			m.Timestamp = ts.AddDate(time.Now().Year(), 0, 0)
+			s = strings.TrimPrefix(s[len(layout):], " ")
+			break
+		}
+	}
+	if m.Timestamp.IsZero() {
+		if field, rest, ok := nextField(s); ok {
+			if ts, err := time.Parse(time.RFC3339Nano, field); err == nil {
+				m.Timestamp = ts
+				s = rest
+			}
+		}
+	}
+
+	// Hostname is present only if timestamp is present, and it is not followed by a tag terminator
+	if !m.Timestamp.IsZero() {
+		if field, rest, ok := nextField(s); ok && !isTag(field) {
+			m.Hostname = field
+			s = rest
+		}
+	}
+
+	// Tag ends with ':' or '[pid]:'
+	if field, rest, _ := nextField(s); isTag(field) {
+		field = strings.TrimSuffix(field, ":")
+		if i := strings.IndexByte(field, '['); i >= 0 && strings.HasSuffix(field, "]") {
+			m.ProcID = field[i+1 : len(field)-1]
+			field = field[0:i]
+		}
+		m.AppName = field
+		s = rest
+	}
+
+	m.Message = s
+	return nil
+}
+
+func isTag(field string) bool {
+	return strings.HasSuffix(field, ":") || strings.HasSuffix(field, "]")
+}
+
+// nextField returns the value until next space and the rest of string after it. Returned bool is false if
+// space is not found.
+func nextField(s string) (field, rest string, ok bool) {
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return s, "", false
+	}
+	return s[0:i], s[i+1:], true
+}
+
+func nilToEmpty(s string) string {
+	if s == NilValue {
+		return ""
+	}
+	return s
+}
+
+func isDigits(bs []byte) bool {
+	if len(bs) == 0 {
+		return false
+	}
+	for _, b := range bs {
+		if b < '0' || b > '9' {
+			return false
+		}
+	}
+	return true
+}
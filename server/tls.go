@@ -0,0 +1,385 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCertReloadInterval is the interval to check the changes of TLSListener.CertFile and TLSListener.KeyFile
+// when TLSListener.CertReloadInterval is not defined.
+const DefaultCertReloadInterval = time.Second
+
+// TLSInfo is the record of the TLS handshake of a connection. See ConnectionInfo.TLS.
+type TLSInfo struct {
+	// Version is the TLS version negotiated, for example tls.VersionTLS13. See VersionName.
+	Version uint16
+	// CipherSuite is the cipher suite negotiated. See CipherSuiteName.
+	CipherSuite uint16
+	// NegotiatedProtocol is the application protocol negotiated with ALPN. See TLSListener.NextProtos.
+	NegotiatedProtocol string
+	// ServerName is the server name requested by the client (SNI). See TLSListener.ServerNames.
+	ServerName string
+	// DidResume is true if the session was resumed from a previous connection.
+	DidResume bool
+	// CertSerial is the serial number, in hexadecimal, of the certificate served. It is empty if the session was
+	// resumed or the handshake failed before. See TLSListener.RotateCertificate.
+	CertSerial string
+	// PeerCertificates are the certificates sent by the client, leaf first. The DER encoding of each one is in its
+	// Raw field.
+	PeerCertificates []*x509.Certificate
+	// VerifiedChains are the chains built to verify the client certificate, if it was verified. See
+	// TLSListener.ClientAuth.
+	VerifiedChains [][]*x509.Certificate
+	// HandshakeDuration is the time spent in the handshake.
+	HandshakeDuration time.Duration
+	// Err is the error if the handshake failed. The rest of fields are the ones known at the moment of the error.
+	Err error
+}
+
+// ErrUnknownServerName is the error of the handshakes rejected because the server name requested is unknown. See
+// TLSListener.UnknownServerNameAlert.
+var ErrUnknownServerName = errors.New("unknown server name")
+
+// TLSAlert is the description of a TLS alert (RFC 8446 section 6).
+type TLSAlert uint8
+
+const (
+	// TLSAlertHandshakeFailure is the handshake_failure alert.
+	TLSAlertHandshakeFailure TLSAlert = 40
+	// TLSAlertAccessDenied is the access_denied alert.
+	TLSAlertAccessDenied TLSAlert = 49
+	// TLSAlertInternalError is the internal_error alert.
+	TLSAlertInternalError TLSAlert = 80
+	// TLSAlertUnrecognizedName is the unrecognized_name alert.
+	TLSAlertUnrecognizedName TLSAlert = 112
+)
+
+// unknownServerNameError is the error of a handshake rejected because the server name requested is unknown. alert
+// is the TLS alert sent to the client.
+type unknownServerNameError struct {
+	serverName string
+	alert      TLSAlert
+}
+
+// Error implements the error interface.
+func (e *unknownServerNameError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrUnknownServerName, e.serverName)
+}
+
+// Unwrap returns ErrUnknownServerName.
+func (e *unknownServerNameError) Unwrap() error {
+	return ErrUnknownServerName
+}
+
+// handshakeConn is the raw connection used by a TLS handshake. crypto/tls sends the internal_error alert when
+// GetConfigForClient fails, so the alert is replaced by the one of the rejection to send it only once.
+type handshakeConn struct {
+	net.Conn
+	// rejected is the error returned by GetConfigForClient if the handshake was rejected. It is only used by the
+	// goroutine of the handshake.
+	rejected *unknownServerNameError
+}
+
+// Write writes b in the connection replacing the alert description if b is the alert record of a rejection.
+func (hc *handshakeConn) Write(b []byte) (int, error) {
+	// Alert record before the server hello is not encrypted: type 21, version, length 2, level and description
+	if hc.rejected != nil && len(b) == 7 && b[0] == 21 {
+		record := append([]byte(nil), b...)
+		record[6] = byte(hc.rejected.alert)
+		return hc.Conn.Write(record)
+	}
+	return hc.Conn.Write(b)
+}
+
+// newTLSInfo returns the record of a handshake.
+func newTLSInfo(cs tls.ConnectionState, duration time.Duration, err error) *TLSInfo {
+	return &TLSInfo{
+		Version:            cs.Version,
+		CipherSuite:        cs.CipherSuite,
+		NegotiatedProtocol: cs.NegotiatedProtocol,
+		ServerName:         cs.ServerName,
+		DidResume:          cs.DidResume,
+		PeerCertificates:   cs.PeerCertificates,
+		VerifiedChains:     cs.VerifiedChains,
+		HandshakeDuration:  duration,
+		Err:                err,
+	}
+}
+
+// VersionName returns the name of the TLS version, for example "TLS 1.3".
+func (ti *TLSInfo) VersionName() string {
+	switch ti.Version {
+	case 0:
+		return ""
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04X", ti.Version)
+}
+
+// CipherSuiteName returns the name of the cipher suite, for example "TLS_AES_128_GCM_SHA256".
+func (ti *TLSInfo) CipherSuiteName() string {
+	if ti.CipherSuite == 0 {
+		return ""
+	}
+	return tls.CipherSuiteName(ti.CipherSuite)
+}
+
+// PeerCertificate returns the leaf certificate sent by the client, or nil if it did not send one.
+func (ti *TLSInfo) PeerCertificate() *x509.Certificate {
+	if len(ti.PeerCertificates) == 0 {
+		return nil
+	}
+	return ti.PeerCertificates[0]
+}
+
+// TLSCertificate is a PEM encoded certificate and its key.
+type TLSCertificate struct {
+	CertPem []byte
+	KeyPem  []byte
+}
+
+// certStore is the certificates served by a TLS listener. They can be replaced while the listener is running.
+type certStore struct {
+	mtx sync.RWMutex
+	// cert is the default certificate.
+	cert *tls.Certificate
+	// names are the certificates by server name in lower case.
+	names map[string]*tls.Certificate
+	// reject is the alert sent when server name is unknown, 0 to serve the default certificate.
+	reject TLSAlert
+	// handshakes are the handshakes in progress by handshakeConn.
+	handshakes sync.Map
+}
+
+// handshakeState is the record of a handshake in progress.
+type handshakeState struct {
+	// serverName is the server name requested.
+	serverName string
+	// cert is the certificate served, nil if it was not served yet or session was resumed.
+	cert *tls.Certificate
+}
+
+// get returns the default certificate.
+func (cs *certStore) get() *tls.Certificate {
+	cs.mtx.RLock()
+	defer cs.mtx.RUnlock()
+	return cs.cert
+}
+
+// set replaces the default certificate.
+func (cs *certStore) set(cert *tls.Certificate) {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+	cs.cert = cert
+}
+
+// setServerNames replaces the certificates by server name and the alert sent when server name is unknown.
+func (cs *certStore) setServerNames(names map[string]*tls.Certificate, reject TLSAlert) {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+	cs.names = names
+	cs.reject = reject
+}
+
+// lookup returns the certificate of the server name, or nil if it is unknown. Wildcard names match any subdomain
+// of one level.
+func (cs *certStore) lookup(serverName string) *tls.Certificate {
+	cs.mtx.RLock()
+	defer cs.mtx.RUnlock()
+	name := strings.TrimSuffix(strings.ToLower(serverName), ".")
+	if cert, found := cs.names[name]; found {
+		return cert
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return cs.names["*"+name[i:]]
+	}
+	return nil
+}
+
+// getConfigForClient implements tls.Config.GetConfigForClient. It registers the handshake of the connection, and
+// it rejects the unknown server names with an unknownServerNameError, so the handshake fails before the server
+// hello.
+func (cs *certStore) getConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	cs.handshakes.Store(hello.Conn, &handshakeState{serverName: hello.ServerName})
+
+	cs.mtx.RLock()
+	reject := cs.reject
+	cs.mtx.RUnlock()
+	if reject == 0 || hello.ServerName == "" || cs.lookup(hello.ServerName) != nil {
+		return nil, nil
+	}
+
+	err := &unknownServerNameError{serverName: hello.ServerName, alert: reject}
+	if hc, ok := hello.Conn.(*handshakeConn); ok {
+		hc.rejected = err
+	}
+	return nil, err
+}
+
+// getCertificate implements tls.Config.GetCertificate. It returns the certificate of the server name requested, or
+// the default certificate, and it saves the certificate served with the handshake.
+func (cs *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := cs.lookup(hello.ServerName)
+	if cert == nil {
+		cert = cs.get()
+	}
+	if cert == nil {
+		return nil, fmt.Errorf("certificate is not loaded")
+	}
+	if v, ok := cs.handshakes.Load(hello.Conn); ok {
+		v.(*handshakeState).cert = cert
+	}
+	return cert, nil
+}
+
+// takeHandshake returns the server name requested and the serial number of the certificate served in the handshake
+// of the connection, and it forgets the handshake. Serial number is empty if no certificate was served, for
+// example if session was resumed.
+func (cs *certStore) takeHandshake(hc *handshakeConn) (string, string) {
+	v, ok := cs.handshakes.Load(hc)
+	if !ok {
+		return "", ""
+	}
+	cs.handshakes.Delete(hc)
+	hs := v.(*handshakeState)
+	if hs.cert == nil {
+		return hs.serverName, ""
+	}
+	return hs.serverName, certSerial(hs.cert.Leaf)
+}
+
+// certSerial returns the serial number of the certificate in hexadecimal.
+func certSerial(c *x509.Certificate) string {
+	if c == nil {
+		return ""
+	}
+	return c.SerialNumber.Text(16)
+}
+
+// loadCertificate parses a PEM encoded certificate and key pair. The Leaf of the certificate returned is defined.
+func loadCertificate(certPem, keyPem []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		return nil, fmt.Errorf("while loads Key and Certificate: %w", err)
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("while parse Certificate: %w", err)
+	}
+	return &cert, nil
+}
+
+// RotateCertificate replaces the PEM encoded default certificate and key served by the listener. New handshakes use
+// the new certificate and the connections already established are not affected. The certificate is not replaced if
+// it can not be loaded. Start loads CertPem and KeyPem (or CertFile and KeyFile) again.
+func (tll *TLSListener) RotateCertificate(certPem, keyPem []byte) error {
+	cert, err := loadCertificate(certPem, keyPem)
+	if err != nil {
+		return err
+	}
+	tll.certs.set(cert)
+	return nil
+}
+
+// tlsEnabled returns true if the certificate of the listener is defined.
+func (tll *TLSListener) tlsEnabled() bool {
+	return len(tll.CertPem) > 0 || tll.CertFile != ""
+}
+
+// loadCertificate loads the certificate of the listener from CertFile and KeyFile if CertFile is defined, or from
+// CertPem and KeyPem otherwise.
+func (tll *TLSListener) loadCertificate() (*tls.Certificate, error) {
+	if tll.CertFile == "" {
+		return loadCertificate(tll.CertPem, tll.KeyPem)
+	}
+
+	certPem, err := os.ReadFile(tll.CertFile)
+	if err != nil {
+		return nil, fmt.Errorf("while read certificate file: %w", err)
+	}
+	keyPem, err := os.ReadFile(tll.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("while read key file: %w", err)
+	}
+	return loadCertificate(certPem, keyPem)
+}
+
+// loadServerNames loads the certificates of ServerNames.
+func (tll *TLSListener) loadServerNames() (map[string]*tls.Certificate, error) {
+	names := make(map[string]*tls.Certificate, len(tll.ServerNames))
+	for name, tc := range tll.ServerNames {
+		cert, err := loadCertificate(tc.CertPem, tc.KeyPem)
+		if err != nil {
+			return nil, fmt.Errorf("while load certificate of server name %s: %w", name, err)
+		}
+		names[strings.TrimSuffix(strings.ToLower(name), ".")] = cert
+	}
+	return names, nil
+}
+
+// certFilesStamp returns a value that changes when CertFile or KeyFile are modified.
+func (tll *TLSListener) certFilesStamp() string {
+	stamp := ""
+	for _, path := range []string{tll.CertFile, tll.KeyFile} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			stamp += "missing;"
+			continue
+		}
+		stamp += fmt.Sprintf("%d-%d;", fi.ModTime().UnixNano(), fi.Size())
+	}
+	return stamp
+}
+
+// watchCertFiles rotates the certificate each time that CertFile or KeyFile change until stopped is closed. It
+// does nothing if CertFile is not defined.
+func (tll *TLSListener) watchCertFiles(stopped <-chan struct{}) {
+	if tll.CertFile == "" {
+		return
+	}
+	interval := tll.CertReloadInterval
+	if interval <= 0 {
+		interval = DefaultCertReloadInterval
+	}
+
+	last := tll.certFilesStamp()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-ticker.C:
+			}
+
+			stamp := tll.certFilesStamp()
+			if stamp == last {
+				continue
+			}
+			// Files can be written one after the other, so a failed load is tried again when they change.
+			last = stamp
+			cert, err := tll.loadCertificate()
+			if err != nil {
+				log.Println("while reload certificate files:", err)
+				continue
+			}
+			tll.certs.set(cert)
+		}
+	}()
+}
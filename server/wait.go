@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// notifier wakes up the goroutines that are waiting for a change. Zero value is ready to use.
+type notifier struct {
+	mtx sync.Mutex
+	ch  chan struct{}
+}
+
+// wait returns a channel that is closed on next notify call.
+func (n *notifier) wait() <-chan struct{} {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+	return n.ch
+}
+
+// notify wakes up all waiting goroutines.
+func (n *notifier) notify() {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+}
+
+// waitUntil blocks until cond returns true or ctx is done. cond is evaluated each time n is notified.
+func (n *notifier) waitUntil(ctx context.Context, cond func() bool) error {
+	for {
+		// Get the channel before check the condition to not lose any notification
+		changed := n.wait()
+		if cond() {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// WaitForPayloads blocks until payloads from n different clients are received or ctx is done. See NPayloadItems.
+func (ps *PayloadStorage) WaitForPayloads(ctx context.Context, n int) error {
+	err := ps.changed.waitUntil(ctx, func() bool { return ps.NPayloadItems() >= n })
+	if err != nil {
+		return fmt.Errorf("while wait for payloads from %d clients: %w", n, err)
+	}
+	return nil
+}
+
+// WaitForRecords blocks until n records are saved or ctx is done.
+func (ps *PayloadStorage) WaitForRecords(ctx context.Context, n int) error {
+	err := ps.changed.waitUntil(ctx, func() bool { return ps.NRecords() >= n })
+	if err != nil {
+		return fmt.Errorf("while wait for %d records: %w", n, err)
+	}
+	return nil
+}
+
+// WaitForBytes blocks until the payload received by the client identified with its source address has n bytes
+// or more, or ctx is done.
+func (ps *PayloadStorage) WaitForBytes(ctx context.Context, remoteAddr string, n int) error {
+	err := ps.changed.waitUntil(ctx, func() bool { return len(ps.GetPayload(remoteAddr)) >= n })
+	if err != nil {
+		return fmt.Errorf("while wait for %d bytes from %s: %w", n, remoteAddr, err)
+	}
+	return nil
+}
+
+// WaitUntil blocks until cond returns true or ctx is done. cond receives the list of records saved and it is
+// evaluated each time a new record is saved.
+func (ps *PayloadStorage) WaitUntil(ctx context.Context, cond func(records []Record) bool) error {
+	err := ps.changed.waitUntil(ctx, func() bool { return cond(ps.Records()) })
+	if err != nil {
+		return fmt.Errorf("while wait for condition over records: %w", err)
+	}
+	return nil
+}
+
+// WaitForConnections blocks until the number of active connections is n or greater, or ctx is done.
+func (scm *ConnectionMgr) WaitForConnections(ctx context.Context, n int) error {
+	err := scm.connsChanged.waitUntil(ctx, func() bool { return scm.Connections() >= n })
+	if err != nil {
+		return fmt.Errorf("while wait for %d active connections: %w", n, err)
+	}
+	return nil
+}
+
+// WaitForNoConnections blocks until there are not any active connection or ctx is done.
+func (scm *ConnectionMgr) WaitForNoConnections(ctx context.Context) error {
+	err := scm.connsChanged.waitUntil(ctx, func() bool { return scm.Connections() <= 0 })
+	if err != nil {
+		return fmt.Errorf("while wait for connections are released: %w", err)
+	}
+	return nil
+}
+
+// WaitForTotalConnections blocks until the number of connections accepted since the server was created is n or
+// greater, or ctx is done. See TotalConnections.
+func (scm *ConnectionMgr) WaitForTotalConnections(ctx context.Context, n int) error {
+	err := scm.connsChanged.waitUntil(ctx, func() bool { return scm.TotalConnections() >= n })
+	if err != nil {
+		return fmt.Errorf("while wait for %d accepted connections: %w", n, err)
+	}
+	return nil
+}